@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"galaxy/cipher/blake3"
+	"galaxy/cipher/chacha20poly1305"
+)
+
+/*
+ * AEAD加密 (SIP004)
+ * 每个连接使用 HKDF-SHA1(key, salt, "ss-subkey") 得到的子密钥
+ * Shadowsocks 2022 (SIP022) 使用 BLAKE3 derive_key(context, key+salt)
+ */
+
+var ssSubkeyInfo = []byte("ss-subkey")
+
+const ss2022SubkeyContext = "shadowsocks 2022 session subkey"
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newChacha20Poly1305(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.New(key)
+}
+
+func newXChacha20Poly1305(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(key)
+}
+
+/* HKDF (RFC 5869), 使用SHA1 */
+func HKDFSHA1(secret, salt, info []byte, size int) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, sha1.Size)
+	}
+	extractor := hmac.New(sha1.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	expander := hmac.New(sha1.New, prk)
+	okm := make([]byte, 0, size+sha1.Size)
+	var last []byte
+	for counter := byte(1); len(okm) < size; counter++ {
+		expander.Reset()
+		expander.Write(last)
+		expander.Write(info)
+		expander.Write([]byte{counter})
+		last = expander.Sum(nil)
+		okm = append(okm, last...)
+	}
+	return okm[:size]
+}
+
+/* 判断是否是AEAD加密方式 */
+func (info *CipherInfo) IsAEAD() bool {
+	return info.AEADFunc != nil
+}
+
+/* 用主密钥和salt创建一个AEAD实例 */
+func (info *CipherInfo) NewAEAD(key, salt []byte) (cipher.AEAD, error) {
+	var subkey []byte
+	if info.SS2022 {
+		subkey = make([]byte, info.KeySize)
+		blake3.DeriveKey(ss2022SubkeyContext, append(append([]byte{}, key...), salt...), subkey)
+	} else {
+		subkey = HKDFSHA1(key, salt, ssSubkeyInfo, info.KeySize)
+	}
+	return info.AEADFunc(subkey)
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+/* RFC 5869 Test Case 4 */
+func TestHKDFSHA1(t *testing.T) {
+	secret, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	expected, _ := hex.DecodeString("085a01ea1b10f36933068b56efa5ad81a4f14b822f5b091568a9cdd4f155fda2c22e422478d305f3f896")
+	if okm := HKDFSHA1(secret, salt, info, 42); !bytes.Equal(okm, expected) {
+		t.Fatalf("Wrong OKM %x", okm)
+	}
+}
+
+func testAEAD(t *testing.T, method string) {
+	info := GetCipherInfo(method)
+	if info == nil || !info.IsAEAD() {
+		t.Fatalf("%s Not AEAD", method)
+	}
+	key := RandKey(info.KeySize)
+	salt := RandKey(info.IvSize)
+	sealer, err := info.NewAEAD(key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opener, err := info.NewAEAD(key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, sealer.NonceSize())
+	plain := []byte("hello galaxy")
+	sealed := sealer.Seal(nil, nonce, plain, nil)
+	if opened, err := opener.Open(nil, nonce, sealed, nil); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, plain) {
+		t.Fatalf("%s Wrong Plaintext", method)
+	}
+}
+
+func TestAEAD(t *testing.T) {
+	testAEAD(t, "aes-128-gcm")
+	testAEAD(t, "aes-192-gcm")
+	testAEAD(t, "aes-256-gcm")
+	testAEAD(t, "chacha20-ietf-poly1305")
+	testAEAD(t, "xchacha20-ietf-poly1305")
+}
@@ -23,33 +23,20 @@ import (
 )
 
 /* AES CFB模式 加密 */
-type aesCFBEncrypter struct {
-	stream cipher.Stream
-}
-
-func (e *aesCFBEncrypter) Encrypt(plain []byte) []byte {
-	return cipherStreamXOR(e.stream, plain)
-}
-
-func newAESCFBEncrypter(key, iv []byte) Encrypter {
-	block, _ := aes.NewCipher(key)
-
-	stream := cipher.NewCFBEncrypter(block, iv)
-	return &aesCFBEncrypter{stream}
+func newAESCFBEncrypter(key, iv []byte) (Encrypter, error) {
+	return newBlockStream(key, iv, aes.NewCipher, cipher.NewCFBEncrypter)
 }
 
 /* AES CFB模式 解密 */
-type aesCFBDecrypter struct {
-	stream cipher.Stream
+func newAESCFBDecrypter(key, iv []byte) (Decrypter, error) {
+	return newBlockStream(key, iv, aes.NewCipher, cipher.NewCFBDecrypter)
 }
 
-func (e *aesCFBDecrypter) Decrypt(encrypted []byte) []byte {
-	return cipherStreamXOR(e.stream, encrypted)
+/* AES CTR模式 加密和解密相同 */
+func newAESCTREncrypter(key, iv []byte) (Encrypter, error) {
+	return newBlockStream(key, iv, aes.NewCipher, cipher.NewCTR)
 }
 
-func newAESCFBDecrypter(key, iv []byte) Decrypter {
-	block, _ := aes.NewCipher(key)
-
-	stream := cipher.NewCFBDecrypter(block, iv)
-	return &aesCFBDecrypter{stream}
+func newAESCTRDecrypter(key, iv []byte) (Decrypter, error) {
+	return newBlockStream(key, iv, aes.NewCipher, cipher.NewCTR)
 }
@@ -0,0 +1,56 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bcrypt
+
+import (
+	"testing"
+)
+
+func TestBcryptingIsEasy(t *testing.T) {
+	pass := []byte("mypassword")
+	hp, err := GenerateFromPassword(pass, MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword error: %s", err)
+	}
+
+	if CompareHashAndPassword(hp, pass) != nil {
+		t.Errorf("%v should hash %s correctly", hp, pass)
+	}
+
+	notPass := "notthepass"
+	err = CompareHashAndPassword(hp, []byte(notPass))
+	if err != ErrMismatchedHashAndPassword {
+		t.Errorf("%v and %s should be mismatched", hp, notPass)
+	}
+}
+
+func TestKnownHashes(t *testing.T) {
+	cases := []struct {
+		password string
+		hash     string
+	}{
+		{"allmine", "$2a$10$XajjQvNhvvRt5GSeFk1xFeyqRrsxkhBkUiQeg0dt.wU1qD4aFDcga"},
+		{"allmine", "$2b$10$XajjQvNhvvRt5GSeFk1xFeyqRrsxkhBkUiQeg0dt.wU1qD4aFDcga"},
+		{"galaxy", "$2y$05$abcdefghijklmnopqrstuuvg3NZjWSfN0/hsjekn/v.ierARSao7y"},
+	}
+	for _, c := range cases {
+		if err := CompareHashAndPassword([]byte(c.hash), []byte(c.password)); err != nil {
+			t.Errorf("%s: %v", c.hash, err)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	cost, err := Cost([]byte("$2a$10$XajjQvNhvvRt5GSeFk1xFeyqRrsxkhBkUiQeg0dt.wU1qD4aFDcga"))
+	if err != nil || cost != 10 {
+		t.Errorf("Wrong Cost %d: %v", cost, err)
+	}
+	if _, err := Cost([]byte("$2a$10$XajjQvNhvvRt5GSeFk1xFe")); err != ErrHashTooShort {
+		t.Errorf("Short Hash Accepted")
+	}
+	if _, err := GenerateFromPassword(make([]byte, 73), MinCost); err != ErrPasswordTooLong {
+		t.Errorf("Long Password Accepted")
+	}
+}
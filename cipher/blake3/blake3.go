@@ -0,0 +1,345 @@
+// Package blake3 implements the BLAKE3 cryptographic hash function, as
+// specified in https://github.com/BLAKE3-team/BLAKE3-specs.
+//
+// This is a straightforward port of the reference implementation. It supports
+// the default hash mode, the keyed hash mode and the key derivation mode, all
+// with extendable output.
+package blake3
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const (
+	// Size is the default size of a BLAKE3 digest, in bytes.
+	Size = 32
+	// KeySize is the size of the key used by the keyed hash mode, in bytes.
+	KeySize = 32
+	// BlockSize is the size of a BLAKE3 block, in bytes.
+	BlockSize = 64
+
+	chunkLen = 1024
+)
+
+const (
+	flagChunkStart = 1 << iota
+	flagChunkEnd
+	flagParent
+	flagRoot
+	flagKeyedHash
+	flagDeriveKeyContext
+	flagDeriveKeyMaterial
+)
+
+var iv = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var msgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+// ErrInvalidKey is returned when the key for the keyed hash mode is not
+// 256 bits long.
+var ErrInvalidKey = errors.New("blake3: invalid key length (must be 256 bits)")
+
+func g(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] = s[a] + s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func round(s *[16]uint32, m *[16]uint32) {
+	// Mix the columns.
+	g(s, 0, 4, 8, 12, m[0], m[1])
+	g(s, 1, 5, 9, 13, m[2], m[3])
+	g(s, 2, 6, 10, 14, m[4], m[5])
+	g(s, 3, 7, 11, 15, m[6], m[7])
+	// Mix the diagonals.
+	g(s, 0, 5, 10, 15, m[8], m[9])
+	g(s, 1, 6, 11, 12, m[10], m[11])
+	g(s, 2, 7, 8, 13, m[12], m[13])
+	g(s, 3, 4, 9, 14, m[14], m[15])
+}
+
+func permute(m *[16]uint32) {
+	var permuted [16]uint32
+	for i := range permuted {
+		permuted[i] = m[msgPermutation[i]]
+	}
+	*m = permuted
+}
+
+func compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		iv[0], iv[1], iv[2], iv[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for i := 0; i < 7; i++ {
+		round(&s, &m)
+		if i < 6 {
+			permute(&m)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func first8(words [16]uint32) [8]uint32 {
+	var cv [8]uint32
+	copy(cv[:], words[:8])
+	return cv
+}
+
+func wordsFromBlock(block []byte) [16]uint32 {
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+	return words
+}
+
+// output is the state just before the chaining value or the root output is
+// computed, so that the root node can produce any amount of output.
+type output struct {
+	inputCV  [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *output) chainingValue() [8]uint32 {
+	return first8(compress(&o.inputCV, &o.block, o.counter, o.blockLen, o.flags))
+}
+
+func (o *output) rootBytes(out []byte) {
+	var counter uint64
+	for len(out) > 0 {
+		words := compress(&o.inputCV, &o.block, counter, o.blockLen, o.flags|flagRoot)
+		var block [BlockSize]byte
+		for i, w := range words {
+			binary.LittleEndian.PutUint32(block[i*4:], w)
+		}
+		n := copy(out, block[:])
+		out = out[n:]
+		counter++
+	}
+}
+
+func parentOutput(left, right [8]uint32, key *[8]uint32, flags uint32) *output {
+	o := &output{
+		inputCV:  *key,
+		blockLen: BlockSize,
+		flags:    flags | flagParent,
+	}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+type chunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [BlockSize]byte
+	blockLen         int
+	blocksCompressed int
+	flags            uint32
+}
+
+func newChunkState(key *[8]uint32, counter uint64, flags uint32) chunkState {
+	return chunkState{
+		cv:      *key,
+		counter: counter,
+		flags:   flags,
+	}
+}
+
+func (c *chunkState) len() int {
+	return BlockSize*c.blocksCompressed + c.blockLen
+}
+
+func (c *chunkState) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return flagChunkStart
+	}
+	return 0
+}
+
+func (c *chunkState) update(input []byte) {
+	for len(input) > 0 {
+		// If the block buffer is full, compress it and clear it. More
+		// input is coming, so this compression is not flagChunkEnd.
+		if c.blockLen == BlockSize {
+			words := wordsFromBlock(c.block[:])
+			c.cv = first8(compress(&c.cv, &words, c.counter, BlockSize, c.flags|c.startFlag()))
+			c.blocksCompressed++
+			c.block = [BlockSize]byte{}
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], input)
+		c.blockLen += n
+		input = input[n:]
+	}
+}
+
+func (c *chunkState) output() *output {
+	return &output{
+		inputCV:  c.cv,
+		block:    wordsFromBlock(c.block[:]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.flags | c.startFlag() | flagChunkEnd,
+	}
+}
+
+// Hasher is an incremental BLAKE3 hasher. It implements hash.Hash, and its
+// Digest method provides extendable output.
+type Hasher struct {
+	chunk   chunkState
+	key     [8]uint32
+	cvStack [][8]uint32
+	flags   uint32
+}
+
+func newHasher(key [8]uint32, flags uint32) *Hasher {
+	return &Hasher{
+		chunk: newChunkState(&key, 0, flags),
+		key:   key,
+		flags: flags,
+	}
+}
+
+// New returns a Hasher for the default hash mode.
+func New() *Hasher {
+	return newHasher(iv, 0)
+}
+
+// NewKeyed returns a Hasher for the keyed hash mode. The key must be 256 bits
+// long.
+func NewKeyed(key []byte) (*Hasher, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	var words [8]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	return newHasher(words, flagKeyedHash), nil
+}
+
+// NewDeriveKey returns a Hasher for the key derivation mode. The context
+// string should be hardcoded, globally unique, and application-specific. The
+// key material is then written to the Hasher.
+func NewDeriveKey(context string) *Hasher {
+	h := newHasher(iv, flagDeriveKeyContext)
+	h.Write([]byte(context))
+	var contextKey [KeySize]byte
+	h.Digest(contextKey[:])
+
+	var words [8]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(contextKey[i*4:])
+	}
+	return newHasher(words, flagDeriveKeyMaterial)
+}
+
+func (h *Hasher) pushStack(cv [8]uint32) {
+	h.cvStack = append(h.cvStack, cv)
+}
+
+func (h *Hasher) popStack() [8]uint32 {
+	cv := h.cvStack[len(h.cvStack)-1]
+	h.cvStack = h.cvStack[:len(h.cvStack)-1]
+	return cv
+}
+
+// addChunkCV merges completed subtrees: the number of trailing zero bits in
+// the total number of chunks is the number of subtrees to merge.
+func (h *Hasher) addChunkCV(cv [8]uint32, totalChunks uint64) {
+	for totalChunks&1 == 0 {
+		cv = parentOutput(h.popStack(), cv, &h.key, h.flags).chainingValue()
+		totalChunks >>= 1
+	}
+	h.pushStack(cv)
+}
+
+// Write adds more data to the running hash. It never returns an error.
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// If the current chunk is complete, finalize it and reset the chunk
+		// state. More input is coming, so this chunk is not flagRoot.
+		if h.chunk.len() == chunkLen {
+			cv := h.chunk.output().chainingValue()
+			totalChunks := h.chunk.counter + 1
+			h.addChunkCV(cv, totalChunks)
+			h.chunk = newChunkState(&h.key, totalChunks, h.flags)
+		}
+		want := chunkLen - h.chunk.len()
+		if want > len(p) {
+			want = len(p)
+		}
+		h.chunk.update(p[:want])
+		p = p[want:]
+	}
+	return n, nil
+}
+
+// Digest fills out with the output of the hash, which can be of any length.
+// It does not change the state of the Hasher.
+func (h *Hasher) Digest(out []byte) {
+	o := h.chunk.output()
+	for i := len(h.cvStack) - 1; i >= 0; i-- {
+		o = parentOutput(h.cvStack[i], o.chainingValue(), &h.key, h.flags)
+	}
+	o.rootBytes(out)
+}
+
+// Sum appends the default 32-byte digest to b and returns the resulting slice.
+// It does not change the state of the Hasher.
+func (h *Hasher) Sum(b []byte) []byte {
+	var out [Size]byte
+	h.Digest(out[:])
+	return append(b, out[:]...)
+}
+
+// Reset resets the Hasher to its initial state, keeping the key and the mode.
+func (h *Hasher) Reset() {
+	h.chunk = newChunkState(&h.key, 0, h.flags)
+	h.cvStack = h.cvStack[:0]
+}
+
+// Size returns the default digest size, 32 bytes.
+func (h *Hasher) Size() int { return Size }
+
+// BlockSize returns the block size of BLAKE3, 64 bytes.
+func (h *Hasher) BlockSize() int { return BlockSize }
+
+// Sum256 returns the default 32-byte BLAKE3 digest of data.
+func Sum256(data []byte) [Size]byte {
+	var out [Size]byte
+	h := New()
+	h.Write(data)
+	h.Digest(out[:])
+	return out
+}
+
+// DeriveKey derives a subkey from the context string and the key material,
+// filling out with the result.
+func DeriveKey(context string, material []byte, out []byte) {
+	h := NewDeriveKey(context)
+	h.Write(material)
+	h.Digest(out)
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"crypto/cipher"
+)
+
+type newBlockFunc func([]byte) (cipher.Block, error)
+type newBlockStreamFunc func(cipher.Block, []byte) cipher.Stream
+
+/* 分组加密的流模式, 如CFB和CTR */
+type blockStream struct {
+	stream cipher.Stream
+}
+
+func (s *blockStream) Encrypt(data []byte) []byte {
+	return cipherStreamXOR(s.stream, data)
+}
+
+func (s *blockStream) Decrypt(data []byte) []byte {
+	return cipherStreamXOR(s.stream, data)
+}
+
+func newBlockStream(key, iv []byte, newBlock newBlockFunc, newStream newBlockStreamFunc) (streamCipher, error) {
+	block, err := newBlock(key)
+	if err != nil {
+		return nil, err
+	} else if len(iv) != block.BlockSize() {
+		return nil, ErrInvalidIvSize
+	}
+	return &blockStream{newStream(block, iv)}, nil
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"crypto/cipher"
+	"galaxy/cipher/blowfish"
+	"galaxy/cipher/cast5"
+)
+
+/*
+ * Blowfish和CAST5 CFB模式
+ * 分组长度只有64位, 仅用于兼容旧的服务
+ */
+
+func newBlowfishBlock(key []byte) (cipher.Block, error) {
+	return blowfish.NewCipher(key)
+}
+
+func newCAST5Block(key []byte) (cipher.Block, error) {
+	return cast5.NewCipher(key)
+}
+
+func newBlowfishCFBEncrypter(key, iv []byte) (Encrypter, error) {
+	return newBlockStream(key, iv, newBlowfishBlock, cipher.NewCFBEncrypter)
+}
+
+func newBlowfishCFBDecrypter(key, iv []byte) (Decrypter, error) {
+	return newBlockStream(key, iv, newBlowfishBlock, cipher.NewCFBDecrypter)
+}
+
+func newCAST5CFBEncrypter(key, iv []byte) (Encrypter, error) {
+	return newBlockStream(key, iv, newCAST5Block, cipher.NewCFBEncrypter)
+}
+
+func newCAST5CFBDecrypter(key, iv []byte) (Decrypter, error) {
+	return newBlockStream(key, iv, newCAST5Block, cipher.NewCFBDecrypter)
+}
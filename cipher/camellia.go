@@ -0,0 +1,32 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"crypto/cipher"
+	"galaxy/cipher/camellia"
+)
+
+/* Camellia CFB模式 */
+func newCamelliaCFBEncrypter(key, iv []byte) (Encrypter, error) {
+	return newBlockStream(key, iv, camellia.NewCipher, cipher.NewCFBEncrypter)
+}
+
+func newCamelliaCFBDecrypter(key, iv []byte) (Decrypter, error) {
+	return newBlockStream(key, iv, camellia.NewCipher, cipher.NewCFBDecrypter)
+}
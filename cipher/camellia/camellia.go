@@ -0,0 +1,289 @@
+// Package camellia implements the Camellia block cipher as specified in
+// RFC 3713.
+//
+// Camellia has a 128-bit block and supports 128, 192 and 256-bit keys.
+package camellia
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"strconv"
+)
+
+// BlockSize is the Camellia block size in bytes.
+const BlockSize = 16
+
+// KeySizeError is returned when the key is not 128, 192 or 256 bits long.
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+	return "camellia: invalid key size " + strconv.Itoa(int(k))
+}
+
+const (
+	sigma1 = 0xA09E667F3BCC908B
+	sigma2 = 0xB67AE8584CAA73B2
+	sigma3 = 0xC6EF372FE94F82BE
+	sigma4 = 0x54FF53A5F1D36F1C
+	sigma5 = 0x10E527FADE682D1D
+	sigma6 = 0xB05688C2B3E6C1FD
+)
+
+// A Cipher is an instance of Camellia using a particular key.
+type Cipher struct {
+	kw [4]uint64  // whitening keys
+	k  [24]uint64 // round keys, 18 of them are used with 128-bit keys
+	ke [6]uint64  // FL/FL^-1 keys, 4 of them are used with 128-bit keys
+	// rounds is 18 for 128-bit keys and 24 for 192 or 256-bit keys.
+	rounds int
+}
+
+// NewCipher creates and returns a Cipher. The key argument should be the
+// Camellia key, 16, 24 or 32 bytes.
+func NewCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, KeySizeError(len(key))
+	}
+	c := new(Cipher)
+	c.expandKey(key)
+	return c, nil
+}
+
+// BlockSize returns the Camellia block size, 16 bytes.
+func (c *Cipher) BlockSize() int { return BlockSize }
+
+// Encrypt encrypts the 16-byte buffer src using the key and stores the result
+// in dst. Note that for amounts of data larger than a block, it is not safe to
+// just call Encrypt on successive blocks; instead, use an encryption mode like
+// CFB (see crypto/cipher).
+func (c *Cipher) Encrypt(dst, src []byte) {
+	d1 := binary.BigEndian.Uint64(src[0:8])
+	d2 := binary.BigEndian.Uint64(src[8:16])
+
+	d1 ^= c.kw[0]
+	d2 ^= c.kw[1]
+	for i := 0; i < c.rounds; i += 2 {
+		if i > 0 && i%6 == 0 {
+			d1 = fl(d1, c.ke[i/3-2])
+			d2 = flinv(d2, c.ke[i/3-1])
+		}
+		d2 ^= f(d1, c.k[i])
+		d1 ^= f(d2, c.k[i+1])
+	}
+	d2 ^= c.kw[2]
+	d1 ^= c.kw[3]
+
+	binary.BigEndian.PutUint64(dst[0:8], d2)
+	binary.BigEndian.PutUint64(dst[8:16], d1)
+}
+
+// Decrypt decrypts the 16-byte buffer src using the key and stores the result
+// in dst.
+func (c *Cipher) Decrypt(dst, src []byte) {
+	d1 := binary.BigEndian.Uint64(src[0:8])
+	d2 := binary.BigEndian.Uint64(src[8:16])
+
+	d1 ^= c.kw[2]
+	d2 ^= c.kw[3]
+	for i := c.rounds - 1; i > 0; i -= 2 {
+		if i < c.rounds-1 && (i+1)%6 == 0 {
+			d1 = fl(d1, c.ke[(i+1)/3-1])
+			d2 = flinv(d2, c.ke[(i+1)/3-2])
+		}
+		d2 ^= f(d1, c.k[i])
+		d1 ^= f(d2, c.k[i-1])
+	}
+	d2 ^= c.kw[0]
+	d1 ^= c.kw[1]
+
+	binary.BigEndian.PutUint64(dst[0:8], d2)
+	binary.BigEndian.PutUint64(dst[8:16], d1)
+}
+
+// uint128 is a 128-bit value, used by the key schedule.
+type uint128 struct {
+	hi, lo uint64
+}
+
+func (x uint128) xor(y uint128) uint128 {
+	return uint128{x.hi ^ y.hi, x.lo ^ y.lo}
+}
+
+// rotl rotates x left by n bits, 0 <= n < 128.
+func (x uint128) rotl(n uint) uint128 {
+	if n >= 64 {
+		x = uint128{x.lo, x.hi}
+		n -= 64
+	}
+	if n == 0 {
+		return x
+	}
+	return uint128{x.hi<<n | x.lo>>(64-n), x.lo<<n | x.hi>>(64-n)}
+}
+
+func (c *Cipher) expandKey(key []byte) {
+	var kl, kr uint128
+	kl.hi = binary.BigEndian.Uint64(key[0:8])
+	kl.lo = binary.BigEndian.Uint64(key[8:16])
+	switch len(key) {
+	case 24:
+		kr.hi = binary.BigEndian.Uint64(key[16:24])
+		kr.lo = ^kr.hi
+	case 32:
+		kr.hi = binary.BigEndian.Uint64(key[16:24])
+		kr.lo = binary.BigEndian.Uint64(key[24:32])
+	}
+
+	t := kl.xor(kr)
+	d1, d2 := t.hi, t.lo
+	d2 ^= f(d1, sigma1)
+	d1 ^= f(d2, sigma2)
+	d1 ^= kl.hi
+	d2 ^= kl.lo
+	d2 ^= f(d1, sigma3)
+	d1 ^= f(d2, sigma4)
+	ka := uint128{d1, d2}
+
+	if len(key) == 16 {
+		c.rounds = 18
+		c.kw[0], c.kw[1] = kl.hi, kl.lo
+		c.k[0], c.k[1] = ka.hi, ka.lo
+		t = kl.rotl(15)
+		c.k[2], c.k[3] = t.hi, t.lo
+		t = ka.rotl(15)
+		c.k[4], c.k[5] = t.hi, t.lo
+		t = ka.rotl(30)
+		c.ke[0], c.ke[1] = t.hi, t.lo
+		t = kl.rotl(45)
+		c.k[6], c.k[7] = t.hi, t.lo
+		t = ka.rotl(45)
+		c.k[8] = t.hi
+		t = kl.rotl(60)
+		c.k[9] = t.lo
+		t = ka.rotl(60)
+		c.k[10], c.k[11] = t.hi, t.lo
+		t = kl.rotl(77)
+		c.ke[2], c.ke[3] = t.hi, t.lo
+		t = kl.rotl(94)
+		c.k[12], c.k[13] = t.hi, t.lo
+		t = ka.rotl(94)
+		c.k[14], c.k[15] = t.hi, t.lo
+		t = kl.rotl(111)
+		c.k[16], c.k[17] = t.hi, t.lo
+		t = ka.rotl(111)
+		c.kw[2], c.kw[3] = t.hi, t.lo
+		return
+	}
+
+	t = ka.xor(kr)
+	d1, d2 = t.hi, t.lo
+	d2 ^= f(d1, sigma5)
+	d1 ^= f(d2, sigma6)
+	kb := uint128{d1, d2}
+
+	c.rounds = 24
+	c.kw[0], c.kw[1] = kl.hi, kl.lo
+	c.k[0], c.k[1] = kb.hi, kb.lo
+	t = kr.rotl(15)
+	c.k[2], c.k[3] = t.hi, t.lo
+	t = ka.rotl(15)
+	c.k[4], c.k[5] = t.hi, t.lo
+	t = kr.rotl(30)
+	c.ke[0], c.ke[1] = t.hi, t.lo
+	t = kb.rotl(30)
+	c.k[6], c.k[7] = t.hi, t.lo
+	t = kl.rotl(45)
+	c.k[8], c.k[9] = t.hi, t.lo
+	t = ka.rotl(45)
+	c.k[10], c.k[11] = t.hi, t.lo
+	t = kl.rotl(60)
+	c.ke[2], c.ke[3] = t.hi, t.lo
+	t = kr.rotl(60)
+	c.k[12], c.k[13] = t.hi, t.lo
+	t = kb.rotl(60)
+	c.k[14], c.k[15] = t.hi, t.lo
+	t = kl.rotl(77)
+	c.k[16], c.k[17] = t.hi, t.lo
+	t = ka.rotl(77)
+	c.ke[4], c.ke[5] = t.hi, t.lo
+	t = kr.rotl(94)
+	c.k[18], c.k[19] = t.hi, t.lo
+	t = ka.rotl(94)
+	c.k[20], c.k[21] = t.hi, t.lo
+	t = kl.rotl(111)
+	c.k[22], c.k[23] = t.hi, t.lo
+	t = kb.rotl(111)
+	c.kw[2], c.kw[3] = t.hi, t.lo
+}
+
+func rotl32(x uint32, n uint) uint32 {
+	return x<<n | x>>(32-n)
+}
+
+func rotl8(x byte, n uint) byte {
+	return x<<n | x>>(8-n)
+}
+
+// f is the Camellia F-function.
+func f(in, k uint64) uint64 {
+	x := in ^ k
+	t1 := sbox1[byte(x>>56)]
+	t2 := rotl8(sbox1[byte(x>>48)], 1)
+	t3 := rotl8(sbox1[byte(x>>40)], 7)
+	t4 := sbox1[rotl8(byte(x>>32), 1)]
+	t5 := rotl8(sbox1[byte(x>>24)], 1)
+	t6 := rotl8(sbox1[byte(x>>16)], 7)
+	t7 := sbox1[rotl8(byte(x>>8), 1)]
+	t8 := sbox1[byte(x)]
+
+	y1 := t1 ^ t3 ^ t4 ^ t6 ^ t7 ^ t8
+	y2 := t1 ^ t2 ^ t4 ^ t5 ^ t7 ^ t8
+	y3 := t1 ^ t2 ^ t3 ^ t5 ^ t6 ^ t8
+	y4 := t2 ^ t3 ^ t4 ^ t5 ^ t6 ^ t7
+	y5 := t1 ^ t2 ^ t6 ^ t7 ^ t8
+	y6 := t2 ^ t3 ^ t5 ^ t7 ^ t8
+	y7 := t3 ^ t4 ^ t5 ^ t6 ^ t8
+	y8 := t1 ^ t4 ^ t5 ^ t6 ^ t7
+	return uint64(y1)<<56 | uint64(y2)<<48 | uint64(y3)<<40 | uint64(y4)<<32 |
+		uint64(y5)<<24 | uint64(y6)<<16 | uint64(y7)<<8 | uint64(y8)
+}
+
+func fl(in, k uint64) uint64 {
+	x1, x2 := uint32(in>>32), uint32(in)
+	k1, k2 := uint32(k>>32), uint32(k)
+	x2 ^= rotl32(x1&k1, 1)
+	x1 ^= x2 | k2
+	return uint64(x1)<<32 | uint64(x2)
+}
+
+func flinv(in, k uint64) uint64 {
+	y1, y2 := uint32(in>>32), uint32(in)
+	k1, k2 := uint32(k>>32), uint32(k)
+	y1 ^= y2 | k2
+	y2 ^= rotl32(y1&k1, 1)
+	return uint64(y1)<<32 | uint64(y2)
+}
+
+// sbox1 is SBOX1 from RFC 3713. SBOX2, SBOX3 and SBOX4 are derived from it:
+// SBOX2[x] = SBOX1[x] <<< 1, SBOX3[x] = SBOX1[x] <<< 7 and
+// SBOX4[x] = SBOX1[x <<< 1].
+var sbox1 = [256]byte{
+	0x70, 0x82, 0x2c, 0xec, 0xb3, 0x27, 0xc0, 0xe5, 0xe4, 0x85, 0x57, 0x35, 0xea, 0x0c, 0xae, 0x41,
+	0x23, 0xef, 0x6b, 0x93, 0x45, 0x19, 0xa5, 0x21, 0xed, 0x0e, 0x4f, 0x4e, 0x1d, 0x65, 0x92, 0xbd,
+	0x86, 0xb8, 0xaf, 0x8f, 0x7c, 0xeb, 0x1f, 0xce, 0x3e, 0x30, 0xdc, 0x5f, 0x5e, 0xc5, 0x0b, 0x1a,
+	0xa6, 0xe1, 0x39, 0xca, 0xd5, 0x47, 0x5d, 0x3d, 0xd9, 0x01, 0x5a, 0xd6, 0x51, 0x56, 0x6c, 0x4d,
+	0x8b, 0x0d, 0x9a, 0x66, 0xfb, 0xcc, 0xb0, 0x2d, 0x74, 0x12, 0x2b, 0x20, 0xf0, 0xb1, 0x84, 0x99,
+	0xdf, 0x4c, 0xcb, 0xc2, 0x34, 0x7e, 0x76, 0x05, 0x6d, 0xb7, 0xa9, 0x31, 0xd1, 0x17, 0x04, 0xd7,
+	0x14, 0x58, 0x3a, 0x61, 0xde, 0x1b, 0x11, 0x1c, 0x32, 0x0f, 0x9c, 0x16, 0x53, 0x18, 0xf2, 0x22,
+	0xfe, 0x44, 0xcf, 0xb2, 0xc3, 0xb5, 0x7a, 0x91, 0x24, 0x08, 0xe8, 0xa8, 0x60, 0xfc, 0x69, 0x50,
+	0xaa, 0xd0, 0xa0, 0x7d, 0xa1, 0x89, 0x62, 0x97, 0x54, 0x5b, 0x1e, 0x95, 0xe0, 0xff, 0x64, 0xd2,
+	0x10, 0xc4, 0x00, 0x48, 0xa3, 0xf7, 0x75, 0xdb, 0x8a, 0x03, 0xe6, 0xda, 0x09, 0x3f, 0xdd, 0x94,
+	0x87, 0x5c, 0x83, 0x02, 0xcd, 0x4a, 0x90, 0x33, 0x73, 0x67, 0xf6, 0xf3, 0x9d, 0x7f, 0xbf, 0xe2,
+	0x52, 0x9b, 0xd8, 0x26, 0xc8, 0x37, 0xc6, 0x3b, 0x81, 0x96, 0x6f, 0x4b, 0x13, 0xbe, 0x63, 0x2e,
+	0xe9, 0x79, 0xa7, 0x8c, 0x9f, 0x6e, 0xbc, 0x8e, 0x29, 0xf5, 0xf9, 0xb6, 0x2f, 0xfd, 0xb4, 0x59,
+	0x78, 0x98, 0x06, 0x6a, 0xe7, 0x46, 0x71, 0xba, 0xd4, 0x25, 0xab, 0x42, 0x88, 0xa2, 0x8d, 0xfa,
+	0x72, 0x07, 0xb9, 0x55, 0xf8, 0xee, 0xac, 0x0a, 0x36, 0x49, 0x2a, 0x68, 0x3c, 0x38, 0xf1, 0xa4,
+	0x40, 0x28, 0xd3, 0x7b, 0xbb, 0xc9, 0x43, 0xc1, 0x15, 0xe3, 0xad, 0xf4, 0x77, 0xc7, 0x80, 0x9e,
+}
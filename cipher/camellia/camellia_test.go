@@ -0,0 +1,60 @@
+package camellia
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+/* RFC 3713 Appendix A */
+var camelliaVectors = []struct {
+	key    string
+	plain  string
+	cipher string
+}{
+	{
+		"0123456789abcdeffedcba9876543210",
+		"0123456789abcdeffedcba9876543210",
+		"67673138549669730857065648eabe43",
+	},
+	{
+		"0123456789abcdeffedcba98765432100011223344556677",
+		"0123456789abcdeffedcba9876543210",
+		"b4993401b3e996f84ee5cee7d79b09b9",
+	},
+	{
+		"0123456789abcdeffedcba987654321000112233445566778899aabbccddeeff",
+		"0123456789abcdeffedcba9876543210",
+		"9acc237dff16d76c20ef7c919e3a7509",
+	},
+}
+
+func TestCamellia(t *testing.T) {
+	for i, v := range camelliaVectors {
+		key, _ := hex.DecodeString(v.key)
+		plain, _ := hex.DecodeString(v.plain)
+		expected, _ := hex.DecodeString(v.cipher)
+
+		c, err := NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, BlockSize)
+		c.Encrypt(out, plain)
+		if !bytes.Equal(out, expected) {
+			t.Fatalf("#%d: Wrong Ciphertext %x", i, out)
+		}
+		c.Decrypt(out, out)
+		if !bytes.Equal(out, plain) {
+			t.Fatalf("#%d: Wrong Plaintext %x", i, out)
+		}
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	for _, size := range []int{0, 8, 15, 17, 33} {
+		if _, err := NewCipher(make([]byte, size)); err == nil {
+			t.Fatalf("Key Size %d Accepted", size)
+		}
+	}
+}
@@ -34,15 +34,63 @@ func (s *chacha20Stream) Decrypt(data []byte) []byte {
 	return cipherStreamXOR(s.stream, data)
 }
 
-func newChacha20Stream(key, iv []byte) *chacha20Stream {
-	stream, _ := chacha20.New(key, iv)
-	return &chacha20Stream{stream}
+type newChachaStreamFunc func([]byte, []byte) (_cipher.Stream, error)
+
+func newChachaStream(key, iv []byte, f newChachaStreamFunc) (streamCipher, error) {
+	stream, err := f(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &chacha20Stream{stream}, nil
+}
+
+func newChacha20Encrypter(key, iv []byte) (Encrypter, error) {
+	return newChachaStream(key, iv, chacha20.New)
+}
+
+func newChacha20Decrypter(key, iv []byte) (Decrypter, error) {
+	return newChachaStream(key, iv, chacha20.New)
+}
+
+/* RFC 7539, 12字节nonce */
+func newChacha20IETFEncrypter(key, iv []byte) (Encrypter, error) {
+	return newChachaStream(key, iv, chacha20.NewIETF)
+}
+
+func newChacha20IETFDecrypter(key, iv []byte) (Decrypter, error) {
+	return newChachaStream(key, iv, chacha20.NewIETF)
+}
+
+/* 24字节nonce */
+func newXChacha20Encrypter(key, iv []byte) (Encrypter, error) {
+	return newChachaStream(key, iv, chacha20.NewXChaCha)
+}
+
+func newXChacha20Decrypter(key, iv []byte) (Decrypter, error) {
+	return newChachaStream(key, iv, chacha20.NewXChaCha)
+}
+
+/* 减少轮数的ChaCha12和ChaCha8 */
+func newChacha12(key, iv []byte) (_cipher.Stream, error) {
+	return chacha20.NewWithRounds(key, iv, 12)
+}
+
+func newChacha8(key, iv []byte) (_cipher.Stream, error) {
+	return chacha20.NewWithRounds(key, iv, 8)
+}
+
+func newChacha12Encrypter(key, iv []byte) (Encrypter, error) {
+	return newChachaStream(key, iv, newChacha12)
+}
+
+func newChacha12Decrypter(key, iv []byte) (Decrypter, error) {
+	return newChachaStream(key, iv, newChacha12)
 }
 
-func newChacha20Encrypter(key, iv []byte) Encrypter {
-	return newChacha20Stream(key, iv)
+func newChacha8Encrypter(key, iv []byte) (Encrypter, error) {
+	return newChachaStream(key, iv, newChacha8)
 }
 
-func newChacha20Decrypter(key, iv []byte) Decrypter {
-	return newChacha20Stream(key, iv)
+func newChacha8Decrypter(key, iv []byte) (Decrypter, error) {
+	return newChachaStream(key, iv, newChacha8)
 }
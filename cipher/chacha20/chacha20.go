@@ -27,6 +27,11 @@ const (
 	KeySize = 32
 	// NonceSize is the length of ChaCha20 nonces, in bytes.
 	NonceSize = 8
+	// IETFNonceSize is the length of ChaCha20 nonces in the IETF variant
+	// (RFC 7539), in bytes.
+	IETFNonceSize = 12
+	// HNonceSize is the length of HChaCha20 nonces, in bytes.
+	HNonceSize = 16
 	// XNonceSize is the length of XChaCha20 nonces, in bytes.
 	XNonceSize = 24
 )
@@ -36,9 +41,15 @@ var (
 	ErrInvalidKey = errors.New("invalid key length (must be 256 bits)")
 	// ErrInvalidNonce is returned when the provided nonce is not 64 bits long.
 	ErrInvalidNonce = errors.New("invalid nonce length (must be 64 bits)")
+	// ErrInvalidIETFNonce is returned when the provided nonce is not 96 bits
+	// long.
+	ErrInvalidIETFNonce = errors.New("invalid nonce length (must be 96 bits)")
 	// ErrInvalidXNonce is returned when the provided nonce is not 192 bits
 	// long.
 	ErrInvalidXNonce = errors.New("invalid nonce length (must be 192 bits)")
+	// ErrInvalidHNonce is returned when the provided nonce is not 128 bits
+	// long.
+	ErrInvalidHNonce = errors.New("invalid nonce length (must be 128 bits)")
 	// ErrInvalidRounds is returned when the provided rounds is not
 	// 8, 12, or 20.
 	ErrInvalidRounds = errors.New("invalid rounds number (must be 8, 12, or 20)")
@@ -74,6 +85,63 @@ func NewWithRounds(key []byte, nonce []byte, rounds uint8) (cipher.Stream, error
 	return s, nil
 }
 
+// NewIETF creates and returns a new cipher.Stream using the IETF variant of
+// ChaCha20 (RFC 7539), which has a 96-bit nonce and a 32-bit block counter.
+// The key argument must be 256 bits long, and the nonce argument must be 96
+// bits long. This Stream instance must not be used to encrypt more than 2^38
+// bytes (256 GiB); XORKeyStream panics once the block counter would wrap.
+func NewIETF(key []byte, nonce []byte) (cipher.Stream, error) {
+	return NewIETFWithRounds(key, nonce, 20)
+}
+
+// NewIETFWithRounds creates and returns a new cipher.Stream just like NewIETF
+// but the rounds number of 8, 12, or 20 can be specified.
+func NewIETFWithRounds(key []byte, nonce []byte, rounds uint8) (cipher.Stream, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != IETFNonceSize {
+		return nil, ErrInvalidIETFNonce
+	}
+
+	if (rounds != 8) && (rounds != 12) && (rounds != 20) {
+		return nil, ErrInvalidRounds
+	}
+
+	s := new(stream)
+	s.init(key, nonce, rounds)
+	s.advance()
+
+	return s, nil
+}
+
+// HChaCha20 derives a 256-bit subkey from the key and the 128-bit nonce, as
+// specified in draft-irtf-cfrg-xchacha. It is the building block of XChaCha20
+// and XChaCha20-Poly1305.
+func HChaCha20(key []byte, nonce []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != HNonceSize {
+		return nil, ErrInvalidHNonce
+	}
+
+	s := new(stream)
+	s.init(key, nonce, 20)
+
+	var out [stateSize]uint32
+	core(&s.state, &out, s.rounds, true)
+
+	subkey := make([]byte, KeySize)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(subkey[i*4:], out[i])
+		binary.LittleEndian.PutUint32(subkey[16+i*4:], out[12+i])
+	}
+	return subkey, nil
+}
+
 // NewXChaCha creates and returns a new cipher.Stream. The key argument must be
 // 256 bits long, and the nonce argument must be 192 bits long. The nonce must
 // be randomly generated or only used once. This Stream instance must not be
@@ -122,6 +190,8 @@ type stream struct {
 	block  [blockSize]byte   // the keystream as an array of 64 bytes
 	offset int               // the offset of used bytes in block
 	rounds uint8
+	ietf   bool // whether the block counter is 32 bits (RFC 7539)
+	done   bool // whether the 32-bit block counter has been used up
 }
 
 func (s *stream) XORKeyStream(dst, src []byte) {
@@ -131,6 +201,9 @@ func (s *stream) XORKeyStream(dst, src []byte) {
 	i := 0
 	max := len(src)
 	for i < max {
+		if s.offset == blockSize {
+			s.advance()
+		}
 		gap := blockSize - s.offset
 
 		limit := i + gap
@@ -146,10 +219,6 @@ func (s *stream) XORKeyStream(dst, src []byte) {
 
 		i += gap
 		s.offset = o
-
-		if o == blockSize {
-			s.advance()
-		}
 	}
 }
 
@@ -176,7 +245,14 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 		s.state[13] = 0
 		s.state[14] = binary.LittleEndian.Uint32(nonce[0:])
 		s.state[15] = binary.LittleEndian.Uint32(nonce[4:])
-	case XNonceSize:
+	case IETFNonceSize:
+		// The IETF variant uses 12 byte nonces and a 32-bit counter.
+		s.state[12] = 0
+		s.state[13] = binary.LittleEndian.Uint32(nonce[0:])
+		s.state[14] = binary.LittleEndian.Uint32(nonce[4:])
+		s.state[15] = binary.LittleEndian.Uint32(nonce[8:])
+		s.ietf = true
+	case XNonceSize, HNonceSize:
 		// XChaCha20 derives the subkey via HChaCha initialized
 		// with the first 16 bytes of the nonce.
 		s.state[12] = binary.LittleEndian.Uint32(nonce[0:])
@@ -184,7 +260,7 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 		s.state[14] = binary.LittleEndian.Uint32(nonce[8:])
 		s.state[15] = binary.LittleEndian.Uint32(nonce[12:])
 	default:
-		// Never happens, both ctors validate the nonce length.
+		// Never happens, the ctors validate the nonce length.
 		panic("invalid nonce size")
 	}
 
@@ -194,8 +270,12 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 // BUG(codahale): Totally untested on big-endian CPUs. Would very much
 // appreciate someone with an ARM device giving this a swing.
 
-// advances the keystream
+// advances the keystream, panics instead of reusing the keystream once the
+// 32-bit counter of the IETF variant wraps around, like golang.org/x/crypto
 func (s *stream) advance() {
+	if s.done {
+		panic("chacha20: counter overflow")
+	}
 	core(&s.state, (*[stateSize]uint32)(unsafe.Pointer(&s.block)), s.rounds, false)
 
 	if bigEndian {
@@ -209,8 +289,10 @@ func (s *stream) advance() {
 	s.offset = 0
 	i := s.state[12] + 1
 	s.state[12] = i
-	if i == 0 {
+	if i == 0 && !s.ietf {
 		s.state[13]++
+	} else if i == 0 {
+		s.done = true
 	}
 }
 
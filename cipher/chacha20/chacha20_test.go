@@ -0,0 +1,143 @@
+package chacha20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var sequentialKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+/* 全零密钥和nonce的第一个块, 见draft-strombergson-chacha-test-vectors TC1 */
+func TestChaChaRounds(t *testing.T) {
+	vectors := []struct {
+		rounds uint8
+		block  string
+	}{
+		{20, "76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586"},
+		{12, "9bf49a6a0755f953811fce125f2683d50429c3bb49e074147e0089a52eae155f0564f879d27ae3c02ce82834acfa8c793a629f2ca0de6919610be82f411326be"},
+		{8, "3e00ef2f895f40d67f5bb8e81f09a5a12c840ec3ce9a7f3b181be188ef711a1e984ce172b9216f419f445367456d5619314a42a3da86b001387bfdb80e0cfe42"},
+	}
+	for _, v := range vectors {
+		s, err := NewWithRounds(make([]byte, KeySize), make([]byte, NonceSize), v.rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := make([]byte, 64)
+		s.XORKeyStream(block, block)
+		if !bytes.Equal(block, mustHex(t, v.block)) {
+			t.Fatalf("ChaCha%d: Wrong Keystream %x", v.rounds, block)
+		}
+	}
+}
+
+/* RFC 7539 2.3.2 */
+func TestIETFBlock(t *testing.T) {
+	s, err := NewIETF(mustHex(t, sequentialKey), mustHex(t, "000000090000004a00000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := mustHex(t, "10f1e7e4d13b5915500fdd1fa32071c4c7d1f4c733c068030422aa9ac3d46c4e"+
+		"d2826446079faa0914c2d705d98b02a2b5129cd1de164eb9cbd083e8a2503c4e")
+	block := make([]byte, 128)
+	s.XORKeyStream(block, block)
+	if !bytes.Equal(block[64:], expected) {
+		t.Fatalf("Wrong Block %x", block[64:])
+	}
+}
+
+/* RFC 7539 2.4.2, 分段加密的结果应与一次加密相同 */
+func TestIETFEncryption(t *testing.T) {
+	key := mustHex(t, sequentialKey)
+	nonce := mustHex(t, "000000000000004a00000000")
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expected := mustHex(t, "6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b"+
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8"+
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736"+
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+
+	for _, step := range []int{len(plain), 1, 7, 64} {
+		s, _ := NewIETF(key, nonce)
+		skip := make([]byte, 64)
+		s.XORKeyStream(skip, skip)
+
+		out := make([]byte, len(plain))
+		for i := 0; i < len(plain); i += step {
+			end := i + step
+			if end > len(plain) {
+				end = len(plain)
+			}
+			s.XORKeyStream(out[i:end], plain[i:end])
+		}
+		if !bytes.Equal(out, expected) {
+			t.Fatalf("Step %d: Wrong Ciphertext %x", step, out)
+		}
+	}
+}
+
+/* draft-irtf-cfrg-xchacha-01 2.2.1 */
+func TestHChaCha20(t *testing.T) {
+	subkey, err := HChaCha20(mustHex(t, sequentialKey), mustHex(t, "000000090000004a0000000031415927"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := mustHex(t, "82413b4227b27bfed30e42508a877d73a0f9e4d58a74a853c12ec41326d3ecdc")
+	if !bytes.Equal(subkey, expected) {
+		t.Fatalf("Wrong Subkey %x", subkey)
+	}
+}
+
+/* XChaCha20(key, nonce) = ChaCha20(HChaCha20(key, nonce[:16]), nonce[16:]) */
+func TestXChaCha(t *testing.T) {
+	key := mustHex(t, sequentialKey)
+	nonce := mustHex(t, "404142434445464748494a4b4c4d4e4f5051525354555658")
+	s, err := NewXChaCha(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, 300)
+	s.XORKeyStream(out, out)
+
+	subkey, _ := HChaCha20(key, nonce[:16])
+	s, _ = New(subkey, nonce[16:])
+	expected := make([]byte, 300)
+	s.XORKeyStream(expected, expected)
+	if !bytes.Equal(out, expected) {
+		t.Fatal("Wrong Keystream")
+	}
+
+	if _, err := NewXChaCha(key, nonce[:NonceSize]); err != ErrInvalidXNonce {
+		t.Fatal("Invalid Nonce Accepted")
+	}
+	if _, err := NewIETF(key, nonce[:NonceSize]); err != ErrInvalidIETFNonce {
+		t.Fatal("Invalid Nonce Accepted")
+	}
+}
+
+func TestIETFCounterOverflow(t *testing.T) {
+	s, err := NewIETF(mustHex(t, sequentialKey), make([]byte, IETFNonceSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* 从倒数第二个块开始, 最后两个块仍然可用 */
+	st := s.(*stream)
+	st.state[12] = 0xfffffffe
+	st.advance()
+	buf := make([]byte, 2*blockSize)
+	s.XORKeyStream(buf, buf)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Counter Overflow Not Detected")
+		}
+	}()
+	s.XORKeyStream(buf[:1], buf[:1])
+}
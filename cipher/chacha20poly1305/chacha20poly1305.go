@@ -0,0 +1,206 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package chacha20poly1305 implements the ChaCha20-Poly1305 AEAD and its
+// extended nonce variant XChaCha20-Poly1305, as specified in RFC 7539 and
+// draft-irtf-cfrg-xchacha.
+//
+// It is built on top of the pure Go chacha20 and poly1305 packages.
+package chacha20poly1305
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"galaxy/cipher/chacha20"
+	"galaxy/cipher/poly1305"
+)
+
+const (
+	// KeySize is the size of the key used by this AEAD, in bytes.
+	KeySize = 32
+	// NonceSize is the size of the nonce used with the standard variant of
+	// this AEAD, in bytes.
+	NonceSize = 12
+	// NonceSizeX is the size of the nonce used with the XChaCha20-Poly1305
+	// variant of this AEAD, in bytes.
+	NonceSizeX = 24
+	// Overhead is the size of the Poly1305 authentication tag, and the
+	// difference between a ciphertext length and its plaintext.
+	Overhead = poly1305.TagSize
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = errors.New("chacha20poly1305: bad key length")
+
+	errOpen = errors.New("chacha20poly1305: message authentication failed")
+)
+
+type chacha20poly1305 struct {
+	key [KeySize]byte
+}
+
+// New returns a ChaCha20-Poly1305 AEAD that uses the given 256-bit key.
+func New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	c := new(chacha20poly1305)
+	copy(c.key[:], key)
+	return c, nil
+}
+
+func (c *chacha20poly1305) NonceSize() int {
+	return NonceSize
+}
+
+func (c *chacha20poly1305) Overhead() int {
+	return Overhead
+}
+
+func (c *chacha20poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Seal")
+	}
+	return seal(c.key[:], dst, nonce, plaintext, additionalData)
+}
+
+func (c *chacha20poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Open")
+	}
+	return open(c.key[:], dst, nonce, ciphertext, additionalData)
+}
+
+type xchacha20poly1305 struct {
+	key [KeySize]byte
+}
+
+// NewX returns a XChaCha20-Poly1305 AEAD that uses the given 256-bit key.
+//
+// XChaCha20-Poly1305 is a ChaCha20-Poly1305 variant that takes a longer
+// nonce, suitable to be generated randomly without risk of collisions.
+func NewX(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	c := new(xchacha20poly1305)
+	copy(c.key[:], key)
+	return c, nil
+}
+
+func (c *xchacha20poly1305) NonceSize() int {
+	return NonceSizeX
+}
+
+func (c *xchacha20poly1305) Overhead() int {
+	return Overhead
+}
+
+/* 通过HChaCha20得到子密钥和12字节的nonce */
+func (c *xchacha20poly1305) subkey(nonce []byte) ([]byte, []byte) {
+	key, _ := chacha20.HChaCha20(c.key[:], nonce[0:16])
+	n := make([]byte, NonceSize)
+	copy(n[4:], nonce[16:24])
+	return key, n
+}
+
+func (c *xchacha20poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSizeX {
+		panic("chacha20poly1305: bad nonce length passed to Seal")
+	}
+	key, n := c.subkey(nonce)
+	return seal(key, dst, n, plaintext, additionalData)
+}
+
+func (c *xchacha20poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSizeX {
+		panic("chacha20poly1305: bad nonce length passed to Open")
+	}
+	key, n := c.subkey(nonce)
+	return open(key, dst, n, ciphertext, additionalData)
+}
+
+/*
+ * 第0个块的前32字节作为poly1305的一次性密钥,
+ * 数据从第1个块开始加密
+ */
+func newStream(key, nonce []byte) (cipher.Stream, *poly1305.MAC) {
+	s, _ := chacha20.NewIETF(key, nonce)
+	var block [64]byte
+	s.XORKeyStream(block[:], block[:])
+	var polyKey [32]byte
+	copy(polyKey[:], block[:32])
+	return s, poly1305.New(&polyKey)
+}
+
+func writeWithPadding(p *poly1305.MAC, b []byte) {
+	p.Write(b)
+	if rem := len(b) % 16; rem != 0 {
+		var buf [16]byte
+		p.Write(buf[:16-rem])
+	}
+}
+
+func writeLengths(p *poly1305.MAC, adlen, clen int) {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[0:], uint64(adlen))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(clen))
+	p.Write(buf[:])
+}
+
+func seal(key, dst, nonce, plaintext, additionalData []byte) []byte {
+	ret, out := sliceForAppend(dst, len(plaintext)+Overhead)
+	ciphertext, tag := out[:len(plaintext)], out[len(plaintext):]
+
+	s, p := newStream(key, nonce)
+	s.XORKeyStream(ciphertext, plaintext)
+
+	writeWithPadding(p, additionalData)
+	writeWithPadding(p, ciphertext)
+	writeLengths(p, len(additionalData), len(ciphertext))
+	p.Sum(tag[:0])
+	return ret
+}
+
+func open(key, dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < Overhead {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-Overhead:]
+	ciphertext = ciphertext[:len(ciphertext)-Overhead]
+
+	s, p := newStream(key, nonce)
+	writeWithPadding(p, additionalData)
+	writeWithPadding(p, ciphertext)
+	writeLengths(p, len(additionalData), len(ciphertext))
+
+	var expected [Overhead]byte
+	p.Sum(expected[:0])
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.XORKeyStream(out, ciphertext)
+	return ret, nil
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes. If the
+// original slice has sufficient capacity then no allocation is performed.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,72 @@
+package chacha20poly1305
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+/* RFC 7539 2.8.2 */
+func TestChacha20Poly1305Vector(t *testing.T) {
+	key := mustHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := mustHex(t, "070000004041424344454647")
+	aad := mustHex(t, "50515253c0c1c2c3c4c5c6c7")
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expected := mustHex(t, "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b6116"+
+		"1ae10b594f09e26a7e902ecbd0600691")
+
+	aead, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := aead.Seal(nil, nonce, plain, aad)
+	if !bytes.Equal(sealed, expected) {
+		t.Fatalf("Wrong Ciphertext %x", sealed)
+	}
+	opened, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, plain) {
+		t.Fatal("Wrong Plaintext")
+	}
+	sealed[0] ^= 1
+	if _, err := aead.Open(nil, nonce, sealed, aad); err == nil {
+		t.Fatal("Tampered Ciphertext Accepted")
+	}
+}
+
+/* draft-irtf-cfrg-xchacha-01 A.3.1 */
+func TestXChacha20Poly1305Vector(t *testing.T) {
+	key := mustHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := mustHex(t, "404142434445464748494a4b4c4d4e4f5051525354555657")
+	aad := mustHex(t, "50515253c0c1c2c3c4c5c6c7")
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expected := mustHex(t, "bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b4522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff921f9664c97637da9768812f615c68b13b52e"+
+		"c0875924c1c7987947deafd8780acf49")
+
+	aead, err := NewX(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := aead.Seal(nil, nonce, plain, aad)
+	if !bytes.Equal(sealed, expected) {
+		t.Fatalf("Wrong Ciphertext %x", sealed)
+	}
+	opened, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, plain) {
+		t.Fatal("Wrong Plaintext")
+	}
+	if _, err := aead.Open(nil, nonce, sealed[:len(sealed)-1], aad); err == nil {
+		t.Fatal("Truncated Ciphertext Accepted")
+	}
+}
@@ -17,8 +17,19 @@
 
 package cipher
 
-type newEncrypterFunc func([]byte, []byte) Encrypter
-type newDecrypterFunc func([]byte, []byte) Decrypter
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* 加密方式的构造函数, 密钥或IV长度不正确时返回错误 */
+type NewEncrypterFunc func(key, iv []byte) (Encrypter, error)
+type NewDecrypterFunc func(key, iv []byte) (Decrypter, error)
+type NewAEADFunc func(key []byte) (cipher.AEAD, error)
 
 type Encrypter interface {
 	Encrypt([]byte) []byte
@@ -28,27 +39,109 @@ type Decrypter interface {
 	Decrypt([]byte) []byte
 }
 
+/* 加密和解密使用同一个密钥流 */
+type streamCipher interface {
+	Encrypter
+	Decrypter
+}
+
+var (
+	ErrInvalidKeySize = errors.New("Invalid Key Size")
+	ErrInvalidIvSize  = errors.New("Invalid IV Size")
+)
+
+/*
+ * 对于AEAD加密方式, IvSize是salt的长度,
+ * EncrypterFunc和DecrypterFunc为nil
+ * SS2022表示使用Shadowsocks 2022 (SIP022) 协议, 密钥是base64编码的PSK
+ */
 type CipherInfo struct {
 	KeySize       int
 	IvSize        int
-	EncrypterFunc newEncrypterFunc
-	DecrypterFunc newDecrypterFunc
+	EncrypterFunc NewEncrypterFunc
+	DecrypterFunc NewDecrypterFunc
+	AEADFunc      NewAEADFunc
+	SS2022        bool
 }
 
 var (
+	cipherLock  sync.RWMutex
 	cipherInfos = map[string]*CipherInfo{
-		"aes-128-cfb": &CipherInfo{16, 16, newAESCFBEncrypter, newAESCFBDecrypter},
-		"aes-192-cfb": &CipherInfo{24, 16, newAESCFBEncrypter, newAESCFBDecrypter},
-		"aes-256-cfb": &CipherInfo{32, 16, newAESCFBEncrypter, newAESCFBDecrypter},
-		"rc4-md5":     &CipherInfo{16, 16, newRC4MD5Encrypter, newRC4MD5Decrypter},
-		"salsa20":     &CipherInfo{32, 8, newSalsa20Encrypter, newSalsa20Decrypter},
-		"chacha20":    &CipherInfo{32, 8, newChacha20Encrypter, newChacha20Decrypter},
-		"none":        &CipherInfo{0, 0, newNoneEncrypter, newNoneDecrypter},
-		"not":         &CipherInfo{0, 0, newNotEncrypter, newNotDecrypter},
+		"aes-128-cfb":      &CipherInfo{16, 16, newAESCFBEncrypter, newAESCFBDecrypter, nil, false},
+		"aes-192-cfb":      &CipherInfo{24, 16, newAESCFBEncrypter, newAESCFBDecrypter, nil, false},
+		"aes-256-cfb":      &CipherInfo{32, 16, newAESCFBEncrypter, newAESCFBDecrypter, nil, false},
+		"aes-128-ctr":      &CipherInfo{16, 16, newAESCTREncrypter, newAESCTRDecrypter, nil, false},
+		"aes-192-ctr":      &CipherInfo{24, 16, newAESCTREncrypter, newAESCTRDecrypter, nil, false},
+		"aes-256-ctr":      &CipherInfo{32, 16, newAESCTREncrypter, newAESCTRDecrypter, nil, false},
+		"camellia-128-cfb": &CipherInfo{16, 16, newCamelliaCFBEncrypter, newCamelliaCFBDecrypter, nil, false},
+		"camellia-192-cfb": &CipherInfo{24, 16, newCamelliaCFBEncrypter, newCamelliaCFBDecrypter, nil, false},
+		"camellia-256-cfb": &CipherInfo{32, 16, newCamelliaCFBEncrypter, newCamelliaCFBDecrypter, nil, false},
+		"bf-cfb":           &CipherInfo{16, 8, newBlowfishCFBEncrypter, newBlowfishCFBDecrypter, nil, false},
+		"cast5-cfb":        &CipherInfo{16, 8, newCAST5CFBEncrypter, newCAST5CFBDecrypter, nil, false},
+		"rc4-md5":          &CipherInfo{16, 16, newRC4MD5Encrypter, newRC4MD5Decrypter, nil, false},
+		"salsa20":          &CipherInfo{32, 8, newSalsa20Encrypter, newSalsa20Decrypter, nil, false},
+		"chacha20":         &CipherInfo{32, 8, newChacha20Encrypter, newChacha20Decrypter, nil, false},
+		"chacha12":         &CipherInfo{32, 8, newChacha12Encrypter, newChacha12Decrypter, nil, false},
+		"chacha8":          &CipherInfo{32, 8, newChacha8Encrypter, newChacha8Decrypter, nil, false},
+		"chacha20-ietf":    &CipherInfo{32, 12, newChacha20IETFEncrypter, newChacha20IETFDecrypter, nil, false},
+		"xchacha20":        &CipherInfo{32, 24, newXChacha20Encrypter, newXChacha20Decrypter, nil, false},
+		"none":             &CipherInfo{0, 0, newNoneEncrypter, newNoneDecrypter, nil, false},
+		"not":              &CipherInfo{0, 0, newNotEncrypter, newNotDecrypter, nil, false},
+
+		"aes-128-gcm":             &CipherInfo{16, 16, nil, nil, newAESGCM, false},
+		"aes-192-gcm":             &CipherInfo{24, 24, nil, nil, newAESGCM, false},
+		"aes-256-gcm":             &CipherInfo{32, 32, nil, nil, newAESGCM, false},
+		"chacha20-ietf-poly1305":  &CipherInfo{32, 32, nil, nil, newChacha20Poly1305, false},
+		"xchacha20-ietf-poly1305": &CipherInfo{32, 32, nil, nil, newXChacha20Poly1305, false},
+
+		"2022-blake3-aes-128-gcm":       &CipherInfo{16, 16, nil, nil, newAESGCM, true},
+		"2022-blake3-aes-256-gcm":       &CipherInfo{32, 32, nil, nil, newAESGCM, true},
+		"2022-blake3-chacha20-poly1305": &CipherInfo{32, 32, nil, nil, newChacha20Poly1305, true},
 	}
 )
 
 func GetCipherInfo(name string) *CipherInfo {
+	cipherLock.RLock()
+	defer cipherLock.RUnlock()
 	info := cipherInfos[name]
 	return info
 }
+
+/*
+ * 注册一个加密方式, 名字不区分大小写
+ * 流加密需要同时提供EncrypterFunc和DecrypterFunc, AEAD加密只需要AEADFunc
+ * SS2022加密方式必须是AEAD
+ */
+func RegisterCipher(name string, info *CipherInfo) error {
+	name = strings.ToLower(name)
+	if name == "" || info == nil {
+		return fmt.Errorf("Invalid Cipher %s", name)
+	} else if info.KeySize < 0 || info.IvSize < 0 {
+		return fmt.Errorf("Invalid Cipher %s", name)
+	} else if info.AEADFunc == nil && (info.EncrypterFunc == nil || info.DecrypterFunc == nil) {
+		return fmt.Errorf("Invalid Cipher %s", name)
+	} else if info.AEADFunc != nil && (info.EncrypterFunc != nil || info.DecrypterFunc != nil) {
+		return fmt.Errorf("Invalid Cipher %s", name)
+	} else if info.SS2022 && info.AEADFunc == nil {
+		return fmt.Errorf("Invalid Cipher %s", name)
+	}
+	cipherLock.Lock()
+	defer cipherLock.Unlock()
+	if _, ok := cipherInfos[name]; ok {
+		return fmt.Errorf("Method %s Already Registered", name)
+	}
+	cipherInfos[name] = info
+	return nil
+}
+
+/* 列出所有加密方式的名字 */
+func ListCiphers() []string {
+	cipherLock.RLock()
+	defer cipherLock.RUnlock()
+	names := make([]string, 0, len(cipherInfos))
+	for name := range cipherInfos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
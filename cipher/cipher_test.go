@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestRegisterCipher(t *testing.T) {
+	info := &CipherInfo{0, 0, newNotEncrypter, newNotDecrypter, nil, false}
+	if err := RegisterCipher("Test-NOT", info); err != nil {
+		t.Fatal(err)
+	}
+	/* 注册是全局的, 结束时删除, 否则-count大于1时重复注册 */
+	t.Cleanup(func() {
+		cipherLock.Lock()
+		delete(cipherInfos, "test-not")
+		cipherLock.Unlock()
+	})
+	if GetCipherInfo("test-not") != info {
+		t.Fatal("Cipher Not Registered")
+	}
+	if err := RegisterCipher("test-not", info); err == nil {
+		t.Fatal("Duplicated Cipher Registered")
+	}
+	if err := RegisterCipher("test-invalid", &CipherInfo{16, 16, newNotEncrypter, nil, nil, false}); err == nil {
+		t.Fatal("Invalid Cipher Registered")
+	}
+	if err := RegisterCipher("test-invalid", &CipherInfo{16, 16, newNotEncrypter, newNotDecrypter, newAESGCM, false}); err == nil {
+		t.Fatal("Invalid Cipher Registered")
+	}
+
+	found := false
+	names := ListCiphers()
+	for i, name := range names {
+		if name == "test-not" {
+			found = true
+		} else if name == "test-invalid" {
+			t.Fatal("Invalid Cipher Listed")
+		}
+		if i > 0 && names[i-1] >= name {
+			t.Fatal("Ciphers Not Sorted")
+		}
+	}
+	if !found {
+		t.Fatal("Cipher Not Listed")
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	if _, err := newAESCFBEncrypter(RandKey(15), RandKey(16)); err == nil {
+		t.Fatal("Invalid AES Key Accepted")
+	}
+	if _, err := newChacha20Decrypter(RandKey(32), RandKey(12)); err == nil {
+		t.Fatal("Invalid Chacha20 Nonce Accepted")
+	}
+	if _, err := newSalsa20Encrypter(RandKey(16), RandKey(8)); err == nil {
+		t.Fatal("Invalid Salsa20 Key Accepted")
+	}
+	if encrypter, err := newChacha20IETFEncrypter(RandKey(31), RandKey(12)); err == nil || encrypter != nil {
+		t.Fatal("Invalid Chacha20 Key Accepted")
+	}
+}
+
+/* 用OpenSSL生成的测试数据 */
+func TestLegacyCiphers(t *testing.T) {
+	plain := []byte("The quick brown fox jumps over the lazy dog, 0123456789!")
+	key16, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	key32, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	iv16, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	iv8 := iv16[:8]
+	vectors := []struct {
+		method    string
+		key       []byte
+		iv        []byte
+		encrypted string
+	}{
+		{"aes-128-ctr", key16, iv16, "32cfa2c84527582bfc71bc755c61c38dd4eeaf20ddeb51ddd78d1ccd0bee3f9eba14b23a1d2192bd9feeec756fa2644343ec536c0b876166"},
+		{"aes-256-ctr", key32, iv16, "c668a8ad52e3e9a8314984262f450d34ac3003609b5f59b11d873a572f05a6faf109013775628cb81cea473cb564c3bd7a5cf61be1c8de03"},
+		{"camellia-128-cfb", key16, iv16, "f24f892aba5e8010012cdf0caf6f556f5c57ad23ee4995b2575f3bd04928bf7ef4b1374f9af3202710065e80ff15ec739c08bc22aa70959f"},
+		{"camellia-256-cfb", key32, iv16, "92503d3816557addd9ebb8a3db744c4131f5f93de9ed732d0cce8269ea556bb37b23fdc714f2154df0ed34c3fd955709b34f862a49ac8a9a"},
+		{"bf-cfb", key16, iv8, "eaa249468e35f14d3d317bee9efddc3cc994f494c89a3faa5f3f222d7af15e994420cbe754e276fd238396a00ff0a665e23e8dc119459605"},
+		{"cast5-cfb", key16, iv8, "1c1b626cdd4e9f81b3a434586bdb21caff262c9ac9f72a6e3bc189ea3e87afb895932251b64d73fdfb249b741ce613cc9834a6b731d6b87e"},
+	}
+	for _, v := range vectors {
+		info := GetCipherInfo(v.method)
+		expected, _ := hex.DecodeString(v.encrypted)
+		encrypter, err := info.EncrypterFunc(v.key, v.iv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted := append(encrypter.Encrypt(append([]byte{}, plain[:5]...)), encrypter.Encrypt(append([]byte{}, plain[5:]...))...)
+		if !bytes.Equal(encrypted, expected) {
+			t.Fatalf("%s: Wrong Ciphertext %x", v.method, encrypted)
+		}
+	}
+}
+
+func testCipherRoundTrip(t *testing.T, name string, info *CipherInfo) {
+	key := RandKey(info.KeySize)
+	iv := RandKey(info.IvSize)
+	plain := bytes.Repeat([]byte("galaxy"), 1000)
+
+	if info.IsAEAD() {
+		aead, err := info.NewAEAD(key, iv)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		nonce := make([]byte, aead.NonceSize())
+		opened, err := aead.Open(nil, nonce, aead.Seal(nil, nonce, plain, nil), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if !bytes.Equal(opened, plain) {
+			t.Fatalf("%s: Wrong Plaintext", name)
+		}
+		return
+	}
+
+	encrypter, err := info.EncrypterFunc(key, iv)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	decrypter, err := info.DecrypterFunc(key, iv)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	var encrypted, decrypted []byte
+	for i := 0; i < len(plain); i += 333 {
+		end := i + 333
+		if end > len(plain) {
+			end = len(plain)
+		}
+		encrypted = append(encrypted, encrypter.Encrypt(append([]byte{}, plain[i:end]...))...)
+	}
+	for i := 0; i < len(encrypted); i += 100 {
+		end := i + 100
+		if end > len(encrypted) {
+			end = len(encrypted)
+		}
+		decrypted = append(decrypted, decrypter.Decrypt(append([]byte{}, encrypted[i:end]...))...)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Fatalf("%s: Wrong Plaintext", name)
+	}
+}
+
+/* 所有已注册的加密方式 */
+func TestAllCiphers(t *testing.T) {
+	for _, name := range ListCiphers() {
+		testCipherRoundTrip(t, name, GetCipherInfo(name))
+	}
+}
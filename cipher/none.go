@@ -28,8 +28,8 @@ func (e *noneEncrypter) Encrypt(plain []byte) []byte {
 	return plain
 }
 
-func newNoneEncrypter(key, iv []byte) Encrypter {
-	return &noneEncrypter{}
+func newNoneEncrypter(key, iv []byte) (Encrypter, error) {
+	return &noneEncrypter{}, nil
 }
 
 type noneDecrypter struct {
@@ -39,6 +39,6 @@ func (e *noneDecrypter) Decrypt(encrypted []byte) []byte {
 	return encrypted
 }
 
-func newNoneDecrypter(key, iv []byte) Decrypter {
-	return &noneDecrypter{}
+func newNoneDecrypter(key, iv []byte) (Decrypter, error) {
+	return &noneDecrypter{}, nil
 }
@@ -31,8 +31,8 @@ func (e *notEncrypter) Encrypt(plain []byte) []byte {
 	return plain
 }
 
-func newNotEncrypter(key, iv []byte) Encrypter {
-	return &notEncrypter{}
+func newNotEncrypter(key, iv []byte) (Encrypter, error) {
+	return &notEncrypter{}, nil
 }
 
 type notDecrypter struct {
@@ -45,6 +45,6 @@ func (e *notDecrypter) Decrypt(encrypted []byte) []byte {
 	return encrypted
 }
 
-func newNotDecrypter(key, iv []byte) Decrypter {
-	return &noneDecrypter{}
+func newNotDecrypter(key, iv []byte) (Decrypter, error) {
+	return &notDecrypter{}, nil
 }
@@ -35,20 +35,23 @@ func (e *rc4MD5Stream) Decrypt(plain []byte) []byte {
 	return cipherStreamXOR(e.stream, plain)
 }
 
-func newRC4MD5Stream(key, iv []byte) *rc4MD5Stream {
+func newRC4MD5Stream(key, iv []byte) (streamCipher, error) {
 	h := md5.New()
 	h.Write(key)
 	h.Write(iv)
 	rc4key := h.Sum(nil)
 
-	stream, _ := rc4.NewCipher(rc4key)
-	return &rc4MD5Stream{stream}
+	stream, err := rc4.NewCipher(rc4key)
+	if err != nil {
+		return nil, err
+	}
+	return &rc4MD5Stream{stream}, nil
 }
 
-func newRC4MD5Encrypter(key, iv []byte) Encrypter {
+func newRC4MD5Encrypter(key, iv []byte) (Encrypter, error) {
 	return newRC4MD5Stream(key, iv)
 }
 
-func newRC4MD5Decrypter(key, iv []byte) Decrypter {
+func newRC4MD5Decrypter(key, iv []byte) (Decrypter, error) {
 	return newRC4MD5Stream(key, iv)
 }
@@ -3,23 +3,38 @@ package cipher
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"io/ioutil"
 )
 
+/* 只有公钥时privKey为nil, 只能加密 */
 type RSA struct {
 	privKey *rsa.PrivateKey
 	pubKey  *rsa.PublicKey
 }
 
+/* 支持PKCS#1 (RSA PRIVATE KEY) 和PKCS#8 (PRIVATE KEY) 格式 */
 func ParseRsaPrivateKeyFromPem(privPEM []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privPEM)
 	if block == nil {
 		return nil, errors.New("failed to parse PEM block containing the key")
 	}
 
+	if block.Type == "PRIVATE KEY" {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("key is not an RSA private key")
+		}
+		return priv, nil
+	}
+
 	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
 		return nil, err
@@ -36,6 +51,36 @@ func ParseRsaPrivateKeyFromFile(filename string) (*rsa.PrivateKey, error) {
 	return ParseRsaPrivateKeyFromPem(data)
 }
 
+/* 支持PKIX (PUBLIC KEY) 和PKCS#1 (RSA PUBLIC KEY) 格式 */
+func ParseRsaPublicKeyFromPem(pubPEM []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pubPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key is not an RSA public key")
+	}
+	return pub, nil
+}
+
+func ParseRsaPublicKeyFromFile(filename string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRsaPublicKeyFromPem(data)
+}
+
 func LoadRSAFromFile(filename string) (*RSA, error) {
 	privKey, err := ParseRsaPrivateKeyFromFile(filename)
 	if err != nil {
@@ -60,10 +105,39 @@ func LoadRSAFromPem(pem []byte) (*RSA, error) {
 	}, nil
 }
 
+func LoadRSAPublicKeyFromFile(filename string) (*RSA, error) {
+	pubKey, err := ParseRsaPublicKeyFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &RSA{
+		pubKey: pubKey,
+	}, nil
+}
+
+func LoadRSAPublicKeyFromPem(pem []byte) (*RSA, error) {
+	pubKey, err := ParseRsaPublicKeyFromPem(pem)
+	if err != nil {
+		return nil, err
+	}
+	return &RSA{
+		pubKey: pubKey,
+	}, nil
+}
+
+/* 密文的长度 */
+func (r *RSA) Size() int {
+	return r.pubKey.Size()
+}
+
+/* RSA-OAEP, 使用SHA256 */
 func (r *RSA) Encrypt(plain []byte) ([]byte, error) {
-	return rsa.EncryptPKCS1v15(rand.Reader, r.pubKey, plain)
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, r.pubKey, plain, nil)
 }
 
 func (r *RSA) Decrypt(ciphertext []byte) ([]byte, error) {
-	return rsa.DecryptPKCS1v15(rand.Reader, r.privKey, ciphertext)
+	if r.privKey == nil {
+		return nil, errors.New("private key required for decryption")
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, r.privKey, ciphertext, nil)
 }
@@ -0,0 +1,63 @@
+package cipher
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func testRSA(t *testing.T, priv, pub *RSA) {
+	plain := RandKey(64)
+	ciphertext, err := pub.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ciphertext) != pub.Size() {
+		t.Fatal("Wrong Ciphertext Size")
+	}
+	decrypted, err := priv.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plain) {
+		t.Fatal("Wrong Plaintext")
+	}
+	if _, err := pub.Decrypt(ciphertext); err == nil && pub.privKey == nil {
+		t.Fatal("Decrypted Without Private Key")
+	}
+}
+
+func TestRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	pkix, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	privPEMs := [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}
+	pubPEMs := [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}),
+	}
+	for _, privPEM := range privPEMs {
+		priv, err := LoadRSAFromPem(privPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pubPEM := range pubPEMs {
+			pub, err := LoadRSAPublicKeyFromPem(pubPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testRSA(t, priv, pub)
+		}
+		testRSA(t, priv, priv)
+	}
+	if _, err := LoadRSAPublicKeyFromPem(privPEMs[0]); err == nil {
+		t.Fatal("Private Key Loaded As Public Key")
+	}
+}
@@ -18,20 +18,67 @@
 package cipher
 
 import (
-	"galaxy/cipher/salsa20"
+	"encoding/binary"
+	"galaxy/cipher/salsa20/salsa"
 )
 
+const salsaBlockSize = 64
+
+/*
+ * Salsa20连续密钥流
+ * 记录下一个块的计数器和当前块中已使用的字节数,
+ * 保证多次调用的结果与一次性加密相同
+ */
 type salsaStream struct {
 	nonce   []byte
 	key     [32]byte
-	counter int
+	counter uint64
+	block   [salsaBlockSize]byte
+	offset  int
+}
+
+/* 前8字节为nonce, 后8字节为小端序的块计数器 */
+func (stream *salsaStream) counterBytes() *[16]byte {
+	var c [16]byte
+	copy(c[:8], stream.nonce)
+	binary.LittleEndian.PutUint64(c[8:], stream.counter)
+	return &c
 }
 
 func (stream *salsaStream) XORKeyStream(out, in []byte) {
-	key := stream.key
-	nonce := stream.nonce
+	if stream.offset < salsaBlockSize {
+		n := len(in)
+		if n > salsaBlockSize-stream.offset {
+			n = salsaBlockSize - stream.offset
+		}
+		for i := 0; i < n; i++ {
+			out[i] = in[i] ^ stream.block[stream.offset+i]
+		}
+		stream.offset += n
+		in, out = in[n:], out[n:]
+	}
+	if len(in) == 0 {
+		return
+	}
+
+	full := len(in) - len(in)%salsaBlockSize
+	if full > 0 {
+		salsa.XORKeyStream(out[:full], in[:full], stream.counterBytes(), &stream.key)
+		stream.counter += uint64(full / salsaBlockSize)
+		in, out = in[full:], out[full:]
+	}
 
-	salsa20.XORKeyStream(out, in, nonce, &key)
+	if len(in) > 0 {
+		for i := range stream.block {
+			stream.block[i] = 0
+		}
+		salsa.XORKeyStream(stream.block[:], stream.block[:], stream.counterBytes(), &stream.key)
+		stream.counter++
+		for i := range in {
+			out[i] = in[i] ^ stream.block[i]
+		}
+		stream.offset = len(in)
+	}
 }
 
 func (stream *salsaStream) Encrypt(data []byte) []byte {
@@ -42,16 +89,24 @@ func (stream *salsaStream) Decrypt(data []byte) []byte {
 	return cipherStreamXOR(stream, data)
 }
 
-func newSalsa20Stream(key, iv []byte) *salsaStream {
-	var key32 [32]byte
-	copy(key32[:], key)
-	return &salsaStream{iv, key32, 0}
+func newSalsa20Stream(key, iv []byte) (streamCipher, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	} else if len(iv) != 8 {
+		return nil, ErrInvalidIvSize
+	}
+	stream := &salsaStream{
+		nonce:  iv,
+		offset: salsaBlockSize,
+	}
+	copy(stream.key[:], key)
+	return stream, nil
 }
 
-func newSalsa20Encrypter(key, iv []byte) Encrypter {
+func newSalsa20Encrypter(key, iv []byte) (Encrypter, error) {
 	return newSalsa20Stream(key, iv)
 }
 
-func newSalsa20Decrypter(key, iv []byte) Decrypter {
+func newSalsa20Decrypter(key, iv []byte) (Decrypter, error) {
 	return newSalsa20Stream(key, iv)
 }
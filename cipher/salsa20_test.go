@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+/*
+ * ECRYPT Set 6 测试向量
+ * xor是131072字节密钥流按64字节异或的结果
+ */
+var salsa20Vectors = []struct {
+	key string
+	iv  string
+	xor string
+}{
+	{
+		"0053A6F94C9FF24598EB3E91E4378ADD3083D6297CCF2275C81B6EC11467BA0D",
+		"0D74DB42A91077DE",
+		"C349B6A51A3EC9B712EAED3F90D8BCEE69B7628645F251A996F55260C62EF31FD6C6B0AEA94E136C9D984AD2DF3578F78E457527B03A0450580DD874F63B1AB9",
+	},
+	{
+		"0558ABFE51A4F74A9DF04396E93C8FE23588DB2E81D4277ACD2073C6196CBF12",
+		"167DE44BB21980E7",
+		"C3EAAF32836BACE32D04E1124231EF47E101367D6305413A0EEB07C60698A2876E4D031870A739D6FFDDD208597AFF0A47AC17EDB0167DD67EBA84F1883D4DFD",
+	},
+	{
+		"0A5DB00356A9FC4FA2F5489BEE4194E73A8DE03386D92C7FD22578CB1E71C417",
+		"1F86ED54BB2289F0",
+		"3CD23C3DC90201ACC0CF49B440B6C417F0DC8D8410A716D5314C059E14B1A8D9A9FB8EA3D9C8DAE12B21402F674AA95C67B1FC514E994C9D3F3A6E41DFF5BBA6",
+	},
+	{
+		"0F62B5085BAE0154A7FA4DA0F34699EC3F92E5388BDE3184D72A7DD02376C91C",
+		"288FF65DC42B92F9",
+		"E00EBCCD70D69152725F9987982178A2E2E139C7BCBE04CA8A0E99E318D9AB76F988C8549F75ADD790BA4F81C176DA653C1A043F11A958E169B6D2319F4EEC1A",
+	},
+}
+
+func newTestSalsa20Stream(t *testing.T, key, iv []byte) *salsaStream {
+	stream, err := newSalsa20Stream(key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream.(*salsaStream)
+}
+
+/* 按随机长度分段加密 */
+func xorInChunks(stream *salsaStream, data []byte, r *rand.Rand) []byte {
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); {
+		n := 1 + r.Intn(200)
+		if i+n > len(data) {
+			n = len(data) - i
+		}
+		stream.XORKeyStream(out[i:i+n], data[i:i+n])
+		i += n
+	}
+	return out
+}
+
+func TestSalsa20Vectors(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i, v := range salsa20Vectors {
+		key, _ := hex.DecodeString(v.key)
+		iv, _ := hex.DecodeString(v.iv)
+		expected, _ := hex.DecodeString(v.xor)
+
+		out := xorInChunks(newTestSalsa20Stream(t, key, iv), make([]byte, 131072), r)
+		var xor [64]byte
+		for len(out) > 0 {
+			for j := range xor {
+				xor[j] ^= out[j]
+			}
+			out = out[64:]
+		}
+		if !bytes.Equal(xor[:], expected) {
+			t.Fatalf("#%d: Wrong Keystream", i)
+		}
+	}
+}
+
+func TestSalsa20Chunks(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	key := RandKey(32)
+	iv := RandKey(8)
+	plain := make([]byte, 10000)
+	r.Read(plain)
+
+	expected := make([]byte, len(plain))
+	newTestSalsa20Stream(t, key, iv).XORKeyStream(expected, plain)
+	for i := 0; i < 10; i++ {
+		if out := xorInChunks(newTestSalsa20Stream(t, key, iv), plain, r); !bytes.Equal(out, expected) {
+			t.Fatal("Chunked Output Mismatch")
+		}
+	}
+
+	encrypter, _ := newSalsa20Encrypter(key, iv)
+	encrypted := encrypter.Encrypt(append([]byte{}, plain...))
+	decrypter, _ := newSalsa20Decrypter(key, iv)
+	decrypted := append(decrypter.Decrypt(append([]byte{}, encrypted[:100]...)),
+		decrypter.Decrypt(append([]byte{}, encrypted[100:]...))...)
+	if !bytes.Equal(decrypted, plain) {
+		t.Fatal("Wrong Plaintext")
+	}
+}
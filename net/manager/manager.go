@@ -18,6 +18,7 @@ package manager
 
 import (
 	"galaxy/net/tunnel"
+	"galaxy/net/tunnel/tconn"
 	"sync"
 	"time"
 )
@@ -48,6 +49,73 @@ func (tm *TunnelManager) AddSSLocalTunnel(address, addr string, port uint16, met
 	return tunnel, nil
 }
 
+/* 服务端也是galaxy, 等待服务端连接目标之后再回复客户端 */
+func (tm *TunnelManager) AddGalaxyLocalTunnel(address, addr string, port uint16, method, password string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewSSLocalTunnel(address, addr, port, method, password)
+	if err != nil {
+		return nil, err
+	}
+	tunnel.SetWaitReply(true)
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+func (tm *TunnelManager) AddHTTPLocalTunnel(address, addr string, port uint16, method, password string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewHTTPLocalTunnel(address, addr, port, method, password)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 本地端口同时支持SOCKS5, SOCKS4(a)和HTTP代理 */
+func (tm *TunnelManager) AddMixedLocalTunnel(address, addr string, port uint16, method, password string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewMixedLocalTunnel(address, addr, port, method, password)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 透明代理, 接受iptables REDIRECT转发的连接 */
+func (tm *TunnelManager) AddRedirLocalTunnel(address, addr string, port uint16, method, password string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewRedirLocalTunnel(address, addr, port, method, password)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 透明代理, 接受iptables TPROXY转发的连接 */
+func (tm *TunnelManager) AddTProxyLocalTunnel(address, addr string, port uint16, method, password string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewTProxyLocalTunnel(address, addr, port, method, password)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 端口转发, 本地端口的TCP和UDP经由服务端转发到faddr:fport */
+func (tm *TunnelManager) AddPortForwardTunnel(address, addr string, port uint16, method, password, faddr string, fport uint16) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewPortForwardTunnel(address, addr, port, method, password, faddr, fport)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 反向隧道, 服务端上raddr:rport收到的连接转发到本地的laddr:lport */
+func (tm *TunnelManager) AddReverseTunnel(addr string, port uint16, method, password, raddr string, rport uint16, laddr string, lport uint16) (tunnel.Tunnel, error) {
+	tunnel := tunnel.NewReverseTunnel(addr, port, method, password, raddr, rport, laddr, lport)
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
 func (tm *TunnelManager) AddSSRemoteTunnel(address, method, password string) (tunnel.Tunnel, error) {
 	tunnel, err := tunnel.NewSSRemoteTunnel(address, method, password)
 	if err != nil {
@@ -57,6 +125,61 @@ func (tm *TunnelManager) AddSSRemoteTunnel(address, method, password string) (tu
 	return tunnel, nil
 }
 
+/*
+ * 经由WebSocket连接服务端, path和host是握手请求中的路径和Host
+ * 服务端一定是galaxy, 等待服务端连接目标之后再回复客户端
+ */
+func (tm *TunnelManager) AddWebSocketLocalTunnel(address, addr string, port uint16, method, password, path, host string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewSSLocalTunnel(address, addr, port, method, password)
+	if err != nil {
+		return nil, err
+	}
+	if err := tunnel.SetTransport(tconn.NewWebSocketTransport(path, host)); err != nil {
+		return nil, err
+	}
+	tunnel.SetWaitReply(true)
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 在WebSocket上监听, 可以部署在HTTP反向代理之后 */
+func (tm *TunnelManager) AddWebSocketRemoteTunnel(address, path, method, password string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewSSTransportRemoteTunnel(tconn.NewWebSocketTransport(path, ""), address, method, password)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+func (tm *TunnelManager) AddRSALocalTunnel(address, addr string, port uint16, method, pubkeyFile string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewRSALocalTunnel(address, addr, port, method, pubkeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+func (tm *TunnelManager) AddRSARemoteTunnel(address, method, privkeyFile string) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewRSARemoteTunnel(address, method, privkeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
+/* 返回的Tunnel是*tunnel.SSRemoteTunnel, 可以在运行时添加和删除用户 */
+func (tm *TunnelManager) AddSSMultiRemoteTunnel(address string, users map[string]tconn.SSUser) (tunnel.Tunnel, error) {
+	tunnel, err := tunnel.NewSSMultiRemoteTunnel(address, users)
+	if err != nil {
+		return nil, err
+	}
+	tm.addTunnel(tunnel)
+	return tunnel, nil
+}
+
 func (tm *TunnelManager) Run() {
 	for {
 		time.Sleep(100 * time.Second)
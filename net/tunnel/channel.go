@@ -18,9 +18,19 @@
 package tunnel
 
 import (
+	"fmt"
 	"galaxy/net/tunnel/tconn"
 )
 
+/* 已知用户时在错误前加上用户名 */
+func logError(user string, err error) {
+	if user != "" {
+		fmt.Printf("%s: %v\n", user, err)
+	} else {
+		fmt.Printf("%v\n", err)
+	}
+}
+
 func TConnChanel(tc tconn.IConn, c chan []byte) {
 	defer close(c)
 	for {
@@ -31,3 +41,28 @@ func TConnChanel(tc tconn.IConn, c chan []byte) {
 		}
 	}
 }
+
+/* 在两个连接之间转发数据, 任意一端关闭时返回 */
+func relay(tc1, tc2 tconn.IConn) {
+	c1 := make(chan []byte, 1024)
+	c2 := make(chan []byte, 1024)
+	go TConnChanel(tc1, c1)
+	go TConnChanel(tc2, c2)
+LOOP:
+	for {
+		select {
+		case data, ok := <-c1:
+			if !ok {
+				break LOOP
+			} else if err := tc2.Write(data); err != nil {
+				break LOOP
+			}
+		case data, ok := <-c2:
+			if !ok {
+				break LOOP
+			} else if err := tc1.Write(data); err != nil {
+				break LOOP
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"galaxy/protocol/socks"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func startHTTPTunnels(t *testing.T) (string, func()) {
+	return startLocalTunnels(t, NewHTTPLocalTunnel)
+}
+
+func startLocalTunnels(t *testing.T, newLocal func(string, string, uint16, string, string) (*SSLocalTunnel, error)) (string, func()) {
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr := remote.listener.Addr().(*net.TCPAddr)
+	local, err := newLocal("127.0.0.1:0", "127.0.0.1", uint16(raddr.Port), "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runSSTunnels(local, remote)
+}
+
+func TestHTTPLocalForward(t *testing.T) {
+	address, stop := startHTTPTunnels(t)
+	defer stop()
+	testHTTPForward(t, address)
+}
+
+/* 混合端口上同时使用HTTP和SOCKS5 */
+func TestMixedLocal(t *testing.T) {
+	address, stop := startLocalTunnels(t, NewMixedLocalTunnel)
+	defer stop()
+	testHTTPForward(t, address)
+
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, port); rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+}
+
+func testHTTPForward(t *testing.T, address string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	defer server.Close()
+
+	proxy, _ := url.Parse("http://" + address)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	resp, err := client.Post(server.URL+"/galaxy", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "POST /galaxy hello" {
+		t.Fatalf("Wrong Response %q", body)
+	}
+}
+
+func TestHTTPLocalConnect(t *testing.T) {
+	address, stop := startHTTPTunnels(t)
+	defer stop()
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "CONNECT 127.0.0.1:%d HTTP/1.1\r\nHost: 127.0.0.1:%d\r\n\r\n", port, port)
+	reader := bufio.NewReader(c)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status %d", resp.StatusCode)
+	}
+	c.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("Wrong Echo Data")
+	}
+}
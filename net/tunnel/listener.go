@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/net/tunnel/tconn"
+	"net"
+)
+
+/* 本地隧道监听的代理协议 */
+type proxyListener interface {
+	accept() (tconn.ProxyConn, error)
+	Close()
+	Addr() net.Addr
+	SetAuthenticator(auth tconn.Authenticator) error
+}
+
+type socks5Listener struct {
+	*tconn.Socks5Listener
+}
+
+func (l socks5Listener) accept() (tconn.ProxyConn, error) {
+	c, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type httpListener struct {
+	*tconn.HTTPListener
+}
+
+func (l httpListener) accept() (tconn.ProxyConn, error) {
+	c, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type mixedListener struct {
+	*tconn.MixedListener
+}
+
+func (l mixedListener) accept() (tconn.ProxyConn, error) {
+	c, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type redirListener struct {
+	*tconn.RedirListener
+}
+
+func (l redirListener) accept() (tconn.ProxyConn, error) {
+	c, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
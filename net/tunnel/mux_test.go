@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestSSMux(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	local, remote := newSSTunnels(t, "aes-256-gcm", "galaxy")
+	local.SetMux(2)
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			c.Write(socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build())
+			io.ReadFull(c, make([]byte, 2))
+			c.Write(socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.ATypeIPv4, "127.0.0.1", port).Build())
+			if rep, err := socks.ReadSocks5Reply(c); err != nil || rep.REP != socks.ReplySuccess {
+				t.Errorf("Connect Failed: %v", err)
+				return
+			}
+			data := make([]byte, 64*1024)
+			for j := range data {
+				data[j] = byte(i + j)
+			}
+			c.Write(data)
+			buf := make([]byte, len(data))
+			if _, err := io.ReadFull(c, buf); err != nil {
+				t.Error(err)
+			} else if string(buf) != string(data) {
+				t.Error("Wrong Data")
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := local.pool.Size(); n < 1 || n > 2 {
+		t.Fatalf("%d Mux Connections", n)
+	}
+}
+
+/* 多路复用时也回复服务端连接目标的结果 */
+func TestSSMuxReply(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	local, remote := newSSTunnels(t, "aes-256-gcm", "galaxy")
+	local.SetMux(1)
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, closedPort(t)); rep.REP != socks.ReplyConnectionRefused {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+	rep := testSocks5Connect(t, address, socks.CMDConnect, port)
+	if rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	} else if rep.ADDR != "127.0.0.1" || rep.PORT == 0 {
+		t.Fatalf("Wrong Bound Address %s:%d", rep.ADDR, rep.PORT)
+	}
+}
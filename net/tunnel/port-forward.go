@@ -0,0 +1,278 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ * 端口转发(ss-tunnel), 本地端口收到的连接和数据包都经由服务端转发到固定的目标
+ * 加密方式支持UDP时, 在同一个地址上转发UDP
+ */
+type PortForwardTunnel struct {
+	listener   net.Listener
+	packetConn net.PacketConn
+	sessions   map[string]*forwardSession
+	lock       sync.Mutex
+	udpTimeout time.Duration
+	signal     chan bool
+	addr       string
+	port       uint16
+	method     string
+	password   string
+	faddr      string
+	fport      uint16
+	running    bool
+}
+
+/* 每个UDP客户端地址对应一个Shadowsocks UDP socket */
+type forwardSession struct {
+	pc     *tconn.SSPacketConn
+	client net.Addr
+	lock   sync.Mutex
+	active time.Time
+}
+
+func (s *forwardSession) touch() {
+	s.lock.Lock()
+	s.active = time.Now()
+	s.lock.Unlock()
+}
+
+func (s *forwardSession) idle() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return time.Since(s.active)
+}
+
+func (t *PortForwardTunnel) Name() string {
+	return "PortForward"
+}
+
+func (t *PortForwardTunnel) IsRunning() bool {
+	return t.running
+}
+
+/* faddr:fport是服务端连接的目标 */
+func NewPortForwardTunnel(address, addr string, port uint16, method, password, faddr string, fport uint16) (*PortForwardTunnel, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	var packetConn net.PacketConn
+	if tconn.IsUDPSupported(method) {
+		packetConn, err = net.ListenPacket("udp", listener.Addr().String())
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return &PortForwardTunnel{
+		listener:   listener,
+		packetConn: packetConn,
+		sessions:   make(map[string]*forwardSession),
+		udpTimeout: defaultUDPTimeout,
+		signal:     make(chan bool, 1),
+		addr:       addr,
+		port:       port,
+		method:     method,
+		password:   password,
+		faddr:      faddr,
+		fport:      fport,
+		running:    false,
+	}, nil
+}
+
+/* UDP的空闲超时, 需要在Run之前设置 */
+func (t *PortForwardTunnel) SetUDPTimeout(timeout time.Duration) {
+	t.udpTimeout = timeout
+}
+
+func (t *PortForwardTunnel) Quit() {
+	t.signal <- true
+}
+
+func (t *PortForwardTunnel) end() {
+	t.running = false
+	t.listener.Close()
+	if t.packetConn != nil {
+		t.packetConn.Close()
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, s := range t.sessions {
+		s.pc.Close()
+	}
+}
+
+func (t *PortForwardTunnel) runForward(c net.Conn) {
+	tc := tconn.NewTConn(tconn.NewConn(c))
+	defer tc.Close()
+	ssc, err := tconn.SSDial(t.addr, t.port, t.method, t.password)
+	if err != nil {
+		logError("", err)
+		return
+	}
+	defer ssc.Close()
+	if err := ssc.Start(t.faddr, t.fport); err != nil {
+		logError("", err)
+		return
+	}
+	relay(tc, ssc)
+}
+
+/* 得到客户端对应的会话并更新活动时间, 没有时创建 */
+func (t *PortForwardTunnel) session(client net.Addr) (*forwardSession, error) {
+	key := client.String()
+	if s := t.lookup(key); s != nil {
+		return s, nil
+	}
+	/* 连接服务端时不持有锁, 不阻塞其他会话 */
+	pc, err := tconn.DialSSPacket(t.addr, t.port, t.method, t.password)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if s := t.sessions[key]; s != nil {
+		pc.Close()
+		s.touch()
+		return s, nil
+	}
+	s := &forwardSession{
+		pc:     pc,
+		client: client,
+		active: time.Now(),
+	}
+	t.sessions[key] = s
+	go t.runSession(s, key)
+	return s, nil
+}
+
+/* 在锁内更新活动时间, runSession不会删除刚刚取得的会话 */
+func (t *PortForwardTunnel) lookup(key string) *forwardSession {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := t.sessions[key]
+	if s != nil {
+		s.touch()
+	}
+	return s
+}
+
+/* 超时之后在锁内再检查一次, 期间有新的数据时继续 */
+func (t *PortForwardTunnel) expire(s *forwardSession, key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if s.idle() < t.udpTimeout {
+		return false
+	}
+	delete(t.sessions, key)
+	return true
+}
+
+/* 把服务端转发回来的数据发送给客户端, 空闲超时后删除 */
+func (t *PortForwardTunnel) runSession(s *forwardSession, key string) {
+	defer s.pc.Close()
+	for {
+		s.pc.SetReadDeadline(time.Now().Add(t.udpTimeout - s.idle()))
+		req, err := s.pc.Read()
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() && !t.expire(s, key) {
+				continue
+			}
+			break
+		}
+		s.touch()
+		if _, err := t.packetConn.WriteTo(req.BUF, s.client); err != nil {
+			break
+		}
+	}
+	t.lock.Lock()
+	if t.sessions[key] == s {
+		delete(t.sessions, key)
+	}
+	t.lock.Unlock()
+}
+
+func (t *PortForwardTunnel) runUDP() {
+	buf := make([]byte, 64*1024)
+	atype := socks.GetAddrAType(t.faddr)
+	for {
+		n, from, err := t.packetConn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		req := ss.NewAddressRequest(atype, t.faddr, t.fport)
+		req.BUF = buf[:n]
+		/* 会话可能在取得之后出错关闭, 重新取得一次 */
+		for i := 0; i < 2; i++ {
+			s, err := t.session(from)
+			if err != nil {
+				logError("", err)
+				break
+			} else if err := s.pc.Write(req); err == nil {
+				break
+			}
+		}
+	}
+}
+
+func (t *PortForwardTunnel) Run() {
+	defer t.end()
+	t.running = true
+
+	if t.packetConn != nil {
+		go t.runUDP()
+	}
+	cc := make(chan net.Conn, 128)
+	go func() {
+		defer close(cc)
+		for {
+			if c, err := t.listener.Accept(); err != nil {
+				break
+			} else {
+				cc <- c
+			}
+		}
+	}()
+LOOP:
+	for {
+		select {
+		case quit, _ := <-t.signal:
+			{
+				if quit {
+					break LOOP
+				}
+			}
+		case c, ok := <-cc:
+			{
+				if !ok {
+					break LOOP
+				}
+				go t.runForward(c)
+			}
+		}
+	}
+}
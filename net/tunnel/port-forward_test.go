@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+/* 服务端监听0端口时TCP和UDP的端口不同, port是要测试的那一个 */
+func newPortForward(t *testing.T, port, fport uint16) *PortForwardTunnel {
+	forward, err := NewPortForwardTunnel("127.0.0.1:0", "127.0.0.1", port, "aes-256-gcm", "galaxy", "127.0.0.1", fport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forward
+}
+
+func TestPortForwardTCP(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go remote.Run()
+	defer remote.Quit()
+	forward := newPortForward(t, uint16(remote.listener.Addr().(*net.TCPAddr).Port), port)
+	go forward.Run()
+	defer forward.Quit()
+
+	c, err := net.Dial("tcp", forward.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "hello" {
+		t.Fatalf("Unexpected Data %q", buf)
+	}
+}
+
+func TestPortForwardUDP(t *testing.T) {
+	echo := startUDPEcho(t)
+	defer echo.Close()
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go remote.Run()
+	defer remote.Quit()
+	forward := newPortForward(t, uint16(remote.packetConn.LocalAddr().(*net.UDPAddr).Port), uint16(echo.LocalAddr().(*net.UDPAddr).Port))
+	forward.SetUDPTimeout(200 * time.Millisecond)
+	go forward.Run()
+	defer forward.Quit()
+
+	c, err := net.Dial("udp", forward.packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	buf := make([]byte, 64)
+	for _, data := range []string{"hello", "world"} {
+		c.Write([]byte(data))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if n, err := c.Read(buf); err != nil {
+			t.Fatal(err)
+		} else if string(buf[:n]) != data {
+			t.Fatalf("Unexpected Data %q", buf[:n])
+		}
+	}
+
+	/* 空闲超时之后释放 */
+	time.Sleep(300 * time.Millisecond)
+	forward.lock.Lock()
+	n := len(forward.sessions)
+	forward.lock.Unlock()
+	if n != 0 {
+		t.Fatalf("%d Sessions Not Expired", n)
+	}
+
+	/* 过期之后重新建立会话 */
+	c.Write([]byte("again"))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := c.Read(buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf[:n]) != "again" {
+		t.Fatalf("Unexpected Data %q", buf[:n])
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"bytes"
+	"galaxy/net/tunnel/tconn"
+	"io"
+	"net"
+	"testing"
+)
+
+/* 用假的原始地址代替SO_ORIGINAL_DST, 不需要root和iptables */
+func TestRedirLocal(t *testing.T) {
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr := remote.listener.Addr().(*net.TCPAddr)
+	local, err := NewRedirLocalTunnel("127.0.0.1:0", "127.0.0.1", uint16(raddr.Port), "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	local.listener.(redirListener).SetOriginalDst(func(c net.Conn) (string, uint16, error) {
+		return "127.0.0.1", port, nil
+	})
+	if err := local.SetAuthenticator(tconn.NewMapAuthenticator(map[string]string{"alice": "secret"})); err != tconn.ErrAuthNotSupported {
+		t.Fatalf("Authentication Accepted: %v", err)
+	}
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || !bytes.Equal(buf, []byte("hello")) {
+		t.Fatal("Wrong Echo Data")
+	}
+}
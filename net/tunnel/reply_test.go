@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"testing"
+)
+
+func testSocks5Connect(t *testing.T, address string, cmd byte, port uint16) *socks.Socks5Reply {
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build())
+	io.ReadFull(c, make([]byte, 2))
+	c.Write(socks.NewSocks5Request(socks.Version5, cmd, socks.ATypeIPv4, "127.0.0.1", port).Build())
+	rep, err := socks.ReadSocks5Reply(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+/* 返回一个没有监听的端口 */
+func closedPort(t *testing.T) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestSocks5ReplyWait(t *testing.T) {
+	local, remote := newSSTunnels(t, "aes-256-gcm", "galaxy")
+	local.SetWaitReply(true)
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+
+	rep := testSocks5Connect(t, address, socks.CMDConnect, port)
+	if rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	} else if rep.ADDR != "127.0.0.1" || rep.PORT == 0 || rep.PORT == port {
+		t.Fatalf("Wrong Bound Address %s:%d", rep.ADDR, rep.PORT)
+	}
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, closedPort(t)); rep.REP != socks.ReplyConnectionRefused {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+}
+
+func TestSocks5Reply(t *testing.T) {
+	address, stop := startSSTunnels(t, "aes-256-gcm", "galaxy")
+	defer stop()
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+
+	rep := testSocks5Connect(t, address, socks.CMDConnect, port)
+	if rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	} else if rep.ADDR != "127.0.0.1" || rep.PORT == 0 || rep.PORT == port {
+		t.Fatalf("Wrong Bound Address %s:%d", rep.ADDR, rep.PORT)
+	}
+	if rep := testSocks5Connect(t, address, 0x09, port); rep.REP != socks.ReplyCommandNotSupported {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+}
+
+/* x25519只能连接galaxy的服务端, 默认等待服务端的结果 */
+func TestSocks5ReplyWaitDefault(t *testing.T) {
+	local, remote := newSSTunnels(t, "x25519-aes-256-gcm", "galaxy")
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, closedPort(t)); rep.REP != socks.ReplyConnectionRefused {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	/* 控制连接断开之后重新连接的间隔 */
+	reverseRetryInterval = 5 * time.Second
+)
+
+/*
+ * 反向隧道的客户端, 运行在内网中
+ * 和服务端保持一个控制连接, 请求服务端监听raddr:rport
+ * 服务端收到的连接经由控制连接转发过来, 再连接本地服务laddr:lport
+ */
+type ReverseTunnel struct {
+	signal   chan bool
+	addr     string
+	port     uint16
+	method   string
+	password string
+	raddr    string
+	rport    uint16
+	laddr    string
+	lport    uint16
+	retry    time.Duration
+	lock     sync.Mutex
+	baddr    string
+	bport    uint16
+	running  bool
+}
+
+func (t *ReverseTunnel) Name() string {
+	return "Reverse"
+}
+
+func (t *ReverseTunnel) IsRunning() bool {
+	return t.running
+}
+
+func NewReverseTunnel(addr string, port uint16, method, password, raddr string, rport uint16, laddr string, lport uint16) *ReverseTunnel {
+	return &ReverseTunnel{
+		signal:   make(chan bool, 1),
+		addr:     addr,
+		port:     port,
+		method:   method,
+		password: password,
+		raddr:    raddr,
+		rport:    rport,
+		laddr:    laddr,
+		lport:    lport,
+		retry:    reverseRetryInterval,
+		running:  false,
+	}
+}
+
+/* 服务端实际监听的地址, 没有连接上时为空 */
+func (t *ReverseTunnel) PublicAddr() (string, uint16) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.baddr, t.bport
+}
+
+func (t *ReverseTunnel) setPublicAddr(addr string, port uint16) {
+	t.lock.Lock()
+	t.baddr = addr
+	t.bport = port
+	t.lock.Unlock()
+}
+
+func (t *ReverseTunnel) Quit() {
+	t.signal <- true
+}
+
+/* 建立控制连接, 服务端开始监听之后返回 */
+func (t *ReverseTunnel) connect() (*tconn.MuxSession, error) {
+	ssc, err := tconn.SSDial(t.addr, t.port, t.method, t.password)
+	if err != nil {
+		return nil, err
+	}
+	if err := ssc.StartReverse(t.raddr, t.rport); err != nil {
+		ssc.Close()
+		return nil, err
+	}
+	rep, err := ssc.ReadReply()
+	if err != nil {
+		ssc.Close()
+		return nil, err
+	} else if rep.REP != socks.ReplySuccess {
+		ssc.Close()
+		return nil, tconn.ReplyError(rep.REP)
+	}
+	t.setPublicAddr(rep.ADDR, rep.PORT)
+	return tconn.NewMuxSession(ssc, true), nil
+}
+
+func (t *ReverseTunnel) serve(session *tconn.MuxSession) {
+	defer t.setPublicAddr("", 0)
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			break
+		}
+		go t.runStream(stream)
+	}
+}
+
+func (t *ReverseTunnel) runStream(stream *tconn.MuxStream) {
+	defer stream.Close()
+	c, err := tconn.Dial("tcp", net.JoinHostPort(t.laddr, strconv.Itoa(int(t.lport))))
+	if err != nil {
+		logError("", err)
+		return
+	}
+	tc := tconn.NewTConn(c)
+	defer tc.Close()
+	relay(stream, tc)
+}
+
+func (t *ReverseTunnel) Run() {
+	defer func() {
+		t.running = false
+	}()
+	t.running = true
+
+	for {
+		if session, err := t.connect(); err != nil {
+			logError("", err)
+		} else {
+			done := make(chan bool)
+			go func() {
+				defer close(done)
+				t.serve(session)
+			}()
+			select {
+			case <-t.signal:
+				session.Close()
+				<-done
+				return
+			case <-done:
+			}
+		}
+		select {
+		case <-t.signal:
+			return
+		case <-time.After(t.retry):
+		}
+	}
+}
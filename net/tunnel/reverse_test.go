@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+/* 服务端只允许在127.0.0.1上监听rport, 客户端请求bindAddr:port */
+func newReverseTunnels(t *testing.T, rport uint16, bindAddr string, port, lport uint16) (*ReverseTunnel, func()) {
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rport != 0 {
+		remote.SetReverse("127.0.0.1", rport, rport)
+	}
+	go remote.Run()
+	raddr := remote.listener.Addr().(*net.TCPAddr)
+	reverse := NewReverseTunnel("127.0.0.1", uint16(raddr.Port), "aes-256-gcm", "galaxy", bindAddr, port, "127.0.0.1", lport)
+	return reverse, func() { remote.Quit() }
+}
+
+func waitPublicAddr(t *testing.T, reverse *ReverseTunnel) string {
+	for i := 0; i < 100; i++ {
+		if addr, port := reverse.PublicAddr(); port != 0 {
+			return fmt.Sprintf("%s:%d", addr, port)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Reverse Tunnel Not Connected")
+	return ""
+}
+
+func TestReverseTunnel(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	rport := closedPort(t)
+	reverse, stop := newReverseTunnels(t, rport, "0.0.0.0", rport, port)
+	defer stop()
+	go reverse.Run()
+	address := waitPublicAddr(t, reverse)
+
+	/* 多个连接共用一个控制连接 */
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns[i] = c
+	}
+	for i, c := range conns {
+		data := fmt.Sprintf("hello %d", i)
+		c.Write([]byte(data))
+		buf := make([]byte, len(data))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatal(err)
+		} else if string(buf) != data {
+			t.Fatalf("Unexpected Data %q", buf)
+		}
+	}
+
+	/* 客户端退出之后服务端停止监听 */
+	reverse.Quit()
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp", address); err != nil {
+			return
+		} else {
+			c.Close()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Public Port Not Closed")
+}
+
+func TestReverseTunnelNotAllowed(t *testing.T) {
+	rport := closedPort(t)
+	for _, c := range []struct {
+		rport uint16
+		addr  string
+		port  uint16
+	}{
+		{0, "127.0.0.1", rport},
+		{rport, "127.0.0.1", rport + 1},
+		{rport, "127.0.0.1", 0},
+		{rport, "127.0.0.2", rport},
+	} {
+		reverse, stop := newReverseTunnels(t, c.rport, c.addr, c.port, 1)
+		_, err := reverse.connect()
+		stop()
+		if code, ok := err.(tconn.ReplyError); !ok || byte(code) != socks.ReplyConnectionNowAllowed {
+			t.Fatalf("%s:%d Unexpected Error %v", c.addr, c.port, err)
+		}
+	}
+}
+
+/* 客户端在反向隧道上打开的流被服务端关闭 */
+func TestReverseTunnelClientOpen(t *testing.T) {
+	rport := closedPort(t)
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.SetReverse("127.0.0.1", rport, rport)
+	go remote.Run()
+	defer remote.Quit()
+	raddr := remote.listener.Addr().(*net.TCPAddr)
+
+	ssc, err := tconn.SSDial("127.0.0.1", uint16(raddr.Port), "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ssc.StartReverse("127.0.0.1", rport); err != nil {
+		t.Fatal(err)
+	} else if rep, err := ssc.ReadReply(); err != nil || rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reverse Failed: %v", err)
+	}
+	session := tconn.NewMuxSession(ssc, true)
+	defer session.Close()
+	stream, err := session.Open("127.0.0.1", 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := stream.Read()
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != io.EOF {
+			t.Fatalf("Unexpected Error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Client Stream Not Closed")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"bytes"
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"testing"
+)
+
+/* 启动一个回显服务, 返回其端口 */
+func startEchoServer(t *testing.T) (uint16, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return uint16(l.Addr().(*net.TCPAddr).Port), func() { l.Close() }
+}
+
+func testSocks4Connect(t *testing.T, atype byte, addr string) {
+	address, stop := startSSTunnels(t, "aes-256-gcm", "galaxy")
+	defer stop()
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	req := socks.NewSocks4Request(socks.Version4, socks.CMDConnect, atype, addr, port, "device")
+	c.Write(append(req.Build(), []byte("hello")...))
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := socks.ParseSocks4Reply(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if rep.REP != socks.Socks4ReplyGranted {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || !bytes.Equal(buf, []byte("hello")) {
+		t.Fatal("Wrong Echo Data")
+	}
+}
+
+func TestSocks4Connect(t *testing.T) {
+	testSocks4Connect(t, socks.ATypeIPv4, "127.0.0.1")
+}
+
+func TestSocks4aConnect(t *testing.T) {
+	testSocks4Connect(t, socks.ATypeDomain, "localhost")
+}
+
+func TestSocks4Rejected(t *testing.T) {
+	address, stop := startSSTunnels(t, "aes-256-gcm", "galaxy")
+	defer stop()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(socks.NewSocks4Request(socks.Version4, socks.CMDBind, socks.ATypeIPv4, "127.0.0.1", 80, "").Build())
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if rep, err := socks.ParseSocks4Reply(buf); err != nil {
+		t.Fatal(err)
+	} else if rep.REP != socks.Socks4ReplyRejected {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+}
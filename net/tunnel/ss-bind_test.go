@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"bytes"
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"testing"
+)
+
+/* 创建一对本地和远程隧道, 本地隧道连接远程隧道 */
+func newSSTunnels(t *testing.T, method, password string) (*SSLocalTunnel, *SSRemoteTunnel) {
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", method, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr := remote.listener.Addr().(*net.TCPAddr)
+	local, err := NewSSLocalTunnel("127.0.0.1:0", "127.0.0.1", uint16(raddr.Port), method, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return local, remote
+}
+
+func runSSTunnels(local *SSLocalTunnel, remote *SSRemoteTunnel) (string, func()) {
+	go remote.Run()
+	go local.Run()
+	return local.listener.Addr().String(), func() {
+		local.Quit()
+		remote.Quit()
+	}
+}
+
+/* 启动一对本地和远程隧道, 返回本地SOCKS5地址 */
+func startSSTunnels(t *testing.T, method, password string) (string, func()) {
+	return runSSTunnels(newSSTunnels(t, method, password))
+}
+
+func readSocks5Reply(t *testing.T, c net.Conn) (string, uint16) {
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := socks.ParseSocks5Reply(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+	return rep.ADDR, rep.PORT
+}
+
+func TestSSBind(t *testing.T) {
+	address, stop := startSSTunnels(t, "aes-256-gcm", "galaxy")
+	defer stop()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build())
+	io.ReadFull(c, make([]byte, 2))
+	c.Write(socks.NewSocks5Request(socks.Version5, socks.CMDBind, socks.ATypeIPv4, "0.0.0.0", 0).Build())
+	addr, port := readSocks5Reply(t, c)
+
+	p, err := net.Dial("tcp", (&net.TCPAddr{IP: net.ParseIP(addr), Port: int(port)}).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if _, port := readSocks5Reply(t, c); int(port) != p.LocalAddr().(*net.TCPAddr).Port {
+		t.Fatal("Wrong Peer Address")
+	}
+
+	p.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || !bytes.Equal(buf, []byte("hello")) {
+		t.Fatal("Wrong Data From Peer")
+	}
+	c.Write([]byte("world"))
+	if _, err := io.ReadFull(p, buf); err != nil || !bytes.Equal(buf, []byte("world")) {
+		t.Fatal("Wrong Data To Peer")
+	}
+}
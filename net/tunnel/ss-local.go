@@ -17,18 +17,29 @@
 package tunnel
 
 import (
-	"fmt"
+	"errors"
+	"galaxy/cipher"
 	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
 )
 
+var ErrTransportConflict = errors.New("Transport Conflicts With Upstream Proxy")
+
 type SSLocalTunnel struct {
-	listener *tconn.Socks5Listener
-	signal   chan bool
-	addr     string
-	port     uint16
-	method   string
-	password string
-	running  bool
+	listener  proxyListener
+	signal    chan bool
+	addr      string
+	port      uint16
+	method    string
+	password  string
+	rsa       *cipher.RSA
+	proxy     *tconn.Socks5Proxy
+	transport tconn.Transport
+	pool      *tconn.MuxPool
+	wait      bool
+	running   bool
 }
 
 func (t *SSLocalTunnel) Name() string {
@@ -39,11 +50,56 @@ func (t *SSLocalTunnel) IsRunning() bool {
 	return t.running
 }
 
+/*
+ * 普通的加密方式可能连接其他的Shadowsocks服务端, 默认不等待服务端的结果
+ * 这时回复总是成功, 地址是连接服务端的本地地址; 服务端是galaxy时用SetWaitReply(true)
+ * 得到真实的结果和地址; x25519和RSA默认等待, 多路复用总是等待
+ */
 func NewSSLocalTunnel(address, addr string, port uint16, method, password string) (*SSLocalTunnel, error) {
 	listener, err := tconn.NewSocks5Listener(address)
 	if err != nil {
 		return nil, err
 	}
+	return newSSLocalTunnel(socks5Listener{listener}, addr, port, method, password), nil
+}
+
+/* 本地监听HTTP代理, 支持CONNECT和普通的HTTP请求 */
+func NewHTTPLocalTunnel(address, addr string, port uint16, method, password string) (*SSLocalTunnel, error) {
+	listener, err := tconn.NewHTTPListener(address)
+	if err != nil {
+		return nil, err
+	}
+	return newSSLocalTunnel(httpListener{listener}, addr, port, method, password), nil
+}
+
+/* 在同一个端口上自动识别SOCKS5, SOCKS4(a)和HTTP代理 */
+func NewMixedLocalTunnel(address, addr string, port uint16, method, password string) (*SSLocalTunnel, error) {
+	listener, err := tconn.NewMixedListener(address)
+	if err != nil {
+		return nil, err
+	}
+	return newSSLocalTunnel(mixedListener{listener}, addr, port, method, password), nil
+}
+
+/* 透明代理, 接受iptables REDIRECT转发的连接, 只支持Linux */
+func NewRedirLocalTunnel(address, addr string, port uint16, method, password string) (*SSLocalTunnel, error) {
+	listener, err := tconn.NewRedirListener(address)
+	if err != nil {
+		return nil, err
+	}
+	return newSSLocalTunnel(redirListener{listener}, addr, port, method, password), nil
+}
+
+/* 透明代理, 接受iptables TPROXY转发的连接, 需要CAP_NET_ADMIN */
+func NewTProxyLocalTunnel(address, addr string, port uint16, method, password string) (*SSLocalTunnel, error) {
+	listener, err := tconn.NewTProxyListener(address)
+	if err != nil {
+		return nil, err
+	}
+	return newSSLocalTunnel(redirListener{listener}, addr, port, method, password), nil
+}
+
+func newSSLocalTunnel(listener proxyListener, addr string, port uint16, method, password string) *SSLocalTunnel {
 	return &SSLocalTunnel{
 		listener: listener,
 		signal:   make(chan bool, 1),
@@ -51,8 +107,74 @@ func NewSSLocalTunnel(address, addr string, port uint16, method, password string
 		port:     port,
 		method:   method,
 		password: password,
+		wait:     tconn.IsX25519Method(method),
 		running:  false,
-	}, nil
+	}
+}
+
+/* 用服务端的RSA公钥交换会话密钥, 不需要共享密码 */
+func NewRSALocalTunnel(address, addr string, port uint16, method, pubkeyFile string) (*SSLocalTunnel, error) {
+	key, err := cipher.LoadRSAPublicKeyFromFile(pubkeyFile)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewSSLocalTunnel(address, addr, port, method, "")
+	if err != nil {
+		return nil, err
+	}
+	t.rsa = key
+	t.wait = true
+	return t, nil
+}
+
+/* 经由上游SOCKS5代理连接服务端, 只用于TCP; 已经设置transport时返回ErrTransportConflict */
+func (t *SSLocalTunnel) SetUpstreamProxy(proxy *tconn.Socks5Proxy) error {
+	if proxy != nil && t.transport != nil {
+		return ErrTransportConflict
+	}
+	t.proxy = proxy
+	return nil
+}
+
+/*
+ * 经由transport(例如WebSocket)连接服务端, 只用于TCP, UDP ASSOCIATE会被拒绝
+ * 不能和上游代理同时使用, 已经设置上游代理时返回ErrTransportConflict
+ */
+func (t *SSLocalTunnel) SetTransport(transport tconn.Transport) error {
+	if transport != nil && t.proxy != nil {
+		return ErrTransportConflict
+	}
+	t.transport = transport
+	return nil
+}
+
+/*
+ * 客户端需要通过auth认证, 认证的用户名用于输出日志
+ * 透明代理(REDIRECT和TPROXY)不支持认证, 返回错误
+ */
+func (t *SSLocalTunnel) SetAuthenticator(auth tconn.Authenticator) error {
+	return t.listener.SetAuthenticator(auth)
+}
+
+/*
+ * 等待服务端连接目标之后再回复客户端, 回复中是真实的结果和地址
+ * 只有galaxy的服务端支持, RSA和x25519只能连接galaxy的服务端, 默认开启
+ * 多路复用时总是等待服务端的结果, 不受这个设置影响
+ */
+func (t *SSLocalTunnel) SetWaitReply(wait bool) {
+	t.wait = wait
+}
+
+/*
+ * 多路复用, CONNECT请求共用最多size个到服务端的连接, size为0时不使用
+ * 多路复用时总是等待服务端的结果, 需要在Run之前设置
+ */
+func (t *SSLocalTunnel) SetMux(size int) {
+	if size > 0 {
+		t.pool = tconn.NewMuxPool(size, t.dial)
+	} else {
+		t.pool = nil
+	}
 }
 
 func (t *SSLocalTunnel) Quit() {
@@ -62,49 +184,205 @@ func (t *SSLocalTunnel) Quit() {
 func (t *SSLocalTunnel) end() {
 	t.running = false
 	t.listener.Close()
+	if t.pool != nil {
+		t.pool.Close()
+	}
 }
 
-func (t *SSLocalTunnel) runSSLocal(sc *tconn.Socks5SConn) {
+func (t *SSLocalTunnel) dial() (*tconn.SSLConn, error) {
+	if t.transport != nil {
+		if t.rsa != nil {
+			return tconn.RSATransportDial(t.transport, t.addr, t.port, t.method, t.rsa)
+		}
+		return tconn.SSTransportDial(t.transport, t.addr, t.port, t.method, t.password)
+	}
+	if t.rsa != nil {
+		return tconn.RSAProxyDial(t.proxy, t.addr, t.port, t.method, t.rsa)
+	}
+	return tconn.SSProxyDial(t.proxy, t.addr, t.port, t.method, t.password)
+}
+
+func (t *SSLocalTunnel) dialPacket() (*tconn.SSPacketConn, error) {
+	if t.rsa != nil {
+		return nil, errors.New("UDP Not Supported With RSA")
+	}
+	return tconn.DialSSPacket(t.addr, t.port, t.method, t.password)
+}
+
+func (t *SSLocalTunnel) runSSLocal(sc tconn.ProxyConn) {
 	defer sc.Close()
 	addr, port, err := sc.Start()
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		logError(sc.User(), err)
+		return
+	}
+	/* 混合端口在Start之后才确定协议 */
+	if mc, ok := sc.(*tconn.MixedSConn); ok {
+		sc = mc.Conn()
+	}
+	if sc.Command() == socks.CMDUDPAssociate && t.transport != nil {
+		/* UDP不经过transport, 直接拒绝而不是绕过transport发送 */
+		sc.Reply(socks.ReplyCommandNotSupported, "", 0)
+		logError(sc.User(), errors.New("UDP Not Supported With Transport"))
+		return
+	} else if sc.Command() == socks.CMDUDPAssociate {
+		t.runUDPAssociate(sc.(*tconn.Socks5SConn), addr, port)
+		return
+	} else if sc.Command() == socks.CMDBind {
+		t.runBind(sc.(*tconn.Socks5SConn), addr, port)
+		return
+	} else if t.pool != nil {
+		t.runMux(sc, addr, port)
 		return
 	}
-	ssc, err := tconn.SSDial(t.addr, t.port, t.method, t.password)
-	sc.Notify(addr, port, err == nil)
+	ssc, err := t.dial()
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		sc.Reply(socks.ReplyGeneralFailure, "", 0)
+		logError(sc.User(), err)
 		return
 	}
 	defer ssc.Close()
-	if err := ssc.Start(addr, port); err != nil {
-		fmt.Printf("%v\n", err)
+	if t.wait {
+		err = t.connectWait(sc, ssc, addr, port)
+	} else {
+		err = t.connect(sc, ssc, addr, port)
+	}
+	if err != nil {
+		logError(sc.User(), err)
 		return
 	}
-	c1 := make(chan []byte, 1024)
-	c2 := make(chan []byte, 1024)
-	go TConnChanel(sc, c1)
-	go TConnChanel(ssc, c2)
-LOOP:
-	for {
-		select {
-		case data, ok := <-c1:
-			{
-				if !ok {
-					break LOOP
-				} else if err := ssc.Write(data); err != nil {
-					break LOOP
-				}
+	relay(sc, ssc)
+}
+
+func (t *SSLocalTunnel) runMux(sc tconn.ProxyConn, addr string, port uint16) {
+	stream, err := t.pool.Open(addr, port)
+	if err != nil {
+		sc.Reply(socks.ReplyGeneralFailure, "", 0)
+		logError(sc.User(), err)
+		return
+	}
+	defer stream.Close()
+	/* 等待服务端连接目标的结果 */
+	rep, err := stream.ReadReply()
+	if err != nil {
+		sc.Reply(socks.ReplyGeneralFailure, "", 0)
+		logError(sc.User(), err)
+		return
+	} else if err := sc.Reply(rep.REP, rep.ADDR, rep.PORT); err != nil {
+		return
+	} else if rep.REP != socks.ReplySuccess {
+		logError(sc.User(), tconn.ReplyError(rep.REP))
+		return
+	}
+	relay(sc, stream)
+}
+
+/*
+ * 不等待服务端的结果, 回复中是连接服务端的地址
+ * transport的连接可能不是TCP, 这时回复空地址
+ */
+func (t *SSLocalTunnel) connect(sc tconn.ProxyConn, ssc *tconn.SSLConn, addr string, port uint16) error {
+	baddr, bport := "", uint16(0)
+	if bound, ok := ssc.LocalAddr().(*net.TCPAddr); ok {
+		baddr, bport = bound.IP.String(), uint16(bound.Port)
+	}
+	if err := sc.Reply(socks.ReplySuccess, baddr, bport); err != nil {
+		return err
+	}
+	return ssc.Start(addr, port)
+}
+
+func (t *SSLocalTunnel) connectWait(sc tconn.ProxyConn, ssc *tconn.SSLConn, addr string, port uint16) error {
+	if err := ssc.StartReply(addr, port); err != nil {
+		sc.Reply(socks.ReplyGeneralFailure, "", 0)
+		return err
+	}
+	rep, err := ssc.ReadReply()
+	if err != nil {
+		sc.Reply(socks.ReplyGeneralFailure, "", 0)
+		return err
+	}
+	if err := sc.Reply(rep.REP, rep.ADDR, rep.PORT); err != nil {
+		return err
+	} else if rep.REP != socks.ReplySuccess {
+		return tconn.ReplyError(rep.REP)
+	}
+	return nil
+}
+
+/*
+ * BIND, 由服务端监听端口
+ * 第一个回复是服务端监听的地址, 第二个回复是连接进来的地址
+ */
+func (t *SSLocalTunnel) runBind(sc *tconn.Socks5SConn, addr string, port uint16) {
+	ssc, err := t.dial()
+	if err != nil {
+		sc.Notify(addr, port, false)
+		logError(sc.User(), err)
+		return
+	}
+	defer ssc.Close()
+	if err := ssc.StartBind(addr, port); err != nil {
+		sc.Notify(addr, port, false)
+		return
+	}
+	for i := 0; i < 2; i++ {
+		baddr, bport, err := ssc.ReadAddress()
+		if err != nil {
+			sc.Notify(addr, port, false)
+			logError(sc.User(), err)
+			return
+		} else if err := sc.Notify(baddr, bport, true); err != nil {
+			return
+		}
+	}
+	relay(sc, ssc)
+}
+
+/*
+ * UDP ASSOCIATE, 通过Shadowsocks UDP转发数据包
+ * TCP控制连接关闭时结束
+ */
+func (t *SSLocalTunnel) runUDPAssociate(sc *tconn.Socks5SConn, addr string, port uint16) {
+	uc, err := sc.ListenUDP()
+	if err != nil {
+		sc.Notify(addr, port, false)
+		logError(sc.User(), err)
+		return
+	}
+	defer uc.Close()
+	pc, err := t.dialPacket()
+	uaddr, uport := uc.Addr()
+	sc.Notify(uaddr, uport, err == nil)
+	if err != nil {
+		logError(sc.User(), err)
+		return
+	}
+	defer pc.Close()
+
+	go func() {
+		for {
+			msg, err := uc.ReadFrom()
+			if err != nil {
+				break
 			}
-		case data, ok := <-c2:
-			{
-				if !ok {
-					break LOOP
-				} else if err := sc.Write(data); err != nil {
-					break LOOP
-				}
+			req := ss.NewAddressRequest(msg.ATYP, msg.ADDR, msg.PORT)
+			req.BUF = msg.DATA
+			pc.Write(req)
+		}
+	}()
+	go func() {
+		for {
+			req, err := pc.Read()
+			if err != nil {
+				break
 			}
+			uc.WriteTo(socks.NewSOCKSUDPMessage(0, req.ATYP, req.ADDR, req.PORT, req.BUF))
+		}
+	}()
+	for {
+		if _, err := sc.Read(); err != nil {
+			break
 		}
 	}
 }
@@ -113,11 +391,11 @@ func (t *SSLocalTunnel) Run() {
 	defer t.end()
 	t.running = true
 
-	cc := make(chan *tconn.Socks5SConn, 128)
+	cc := make(chan tconn.ProxyConn, 128)
 	go func() {
 		defer close(cc)
 		for {
-			if c, err := t.listener.Accept(); err != nil {
+			if c, err := t.listener.accept(); err != nil {
 				break
 			} else {
 				cc <- c
@@ -18,16 +18,31 @@ package tunnel
 
 import (
 	"fmt"
+	"galaxy/cipher"
 	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	/* BIND等待连接的时间 */
+	bindTimeout = 2 * time.Minute
 )
 
 /*  Shadowsocks 服务端 */
 type SSRemoteTunnel struct {
-	listener *tconn.SSListener
-	signal   chan bool
-	method   string
-	password string
-	running  bool
+	listener   *tconn.SSListener
+	packetConn *tconn.SSPacketConn
+	nat        *udpNAT
+	proxy      *tconn.Socks5Proxy
+	reverse    *reverseRule
+	signal     chan bool
+	method     string
+	password   string
+	running    bool
 }
 
 func (t *SSRemoteTunnel) IsRunning() bool {
@@ -38,13 +53,106 @@ func (t *SSRemoteTunnel) Name() string {
 	return "Remote"
 }
 
+/* 被拒绝的重放连接数 */
+func (t *SSRemoteTunnel) Replays() uint64 {
+	return t.listener.Replays()
+}
+
+/* 添加和删除用户, 只对多用户服务有效 */
+func (t *SSRemoteTunnel) AddUser(id string, user tconn.SSUser) error {
+	return t.listener.AddUser(id, user)
+}
+
+func (t *SSRemoteTunnel) RemoveUser(id string) {
+	t.listener.RemoveUser(id)
+}
+
+/* UDP转发的空闲超时和最大数量, 运行中修改时对之后建立的转发生效 */
+func (t *SSRemoteTunnel) SetUDPTimeout(timeout time.Duration) {
+	t.nat.setTimeout(timeout)
+}
+
+func (t *SSRemoteTunnel) SetUDPLimit(limit int) {
+	t.nat.setLimit(limit)
+}
+
+/* 经由上游SOCKS5代理连接目标地址, 只用于TCP */
+func (t *SSRemoteTunnel) SetUpstreamProxy(proxy *tconn.Socks5Proxy) {
+	t.proxy = proxy
+}
+
+/*
+ * 允许客户端建立反向隧道, 只能在bindAddr上监听minPort到maxPort之间的端口
+ * 客户端请求的地址是0.0.0.0或者::时使用bindAddr; bindAddr为空时不允许反向隧道
+ */
+func (t *SSRemoteTunnel) SetReverse(bindAddr string, minPort, maxPort uint16) {
+	if bindAddr == "" {
+		t.reverse = nil
+		return
+	}
+	t.reverse = &reverseRule{
+		addr:    bindAddr,
+		minPort: minPort,
+		maxPort: maxPort,
+	}
+}
+
+type reverseRule struct {
+	addr    string
+	minPort uint16
+	maxPort uint16
+}
+
+/* 返回实际监听的地址 */
+func (r *reverseRule) check(addr string, port uint16) (string, error) {
+	if port == 0 || port < r.minPort || port > r.maxPort {
+		return "", tconn.ErrNotAllowed
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.IsUnspecified() {
+		return r.addr, nil
+	} else if addr == r.addr {
+		return addr, nil
+	} else if ip != nil && ip.Equal(net.ParseIP(r.addr)) {
+		return r.addr, nil
+	}
+	return "", tconn.ErrNotAllowed
+}
+
+/* 加密方式支持UDP时, 在同一个地址上监听UDP */
 func NewSSRemoteTunnel(address, method, password string) (*SSRemoteTunnel, error) {
 	listener, err := tconn.NewSSListener(address, method, password)
 	if err != nil {
 		return nil, err
 	}
+	var packetConn *tconn.SSPacketConn
+	if tconn.IsUDPSupported(method) {
+		/* 端口为0时TCP和UDP使用同一个实际端口 */
+		packetConn, err = tconn.ListenSSPacket(listener.Addr().String(), method, password)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return &SSRemoteTunnel{
+		listener:   listener,
+		packetConn: packetConn,
+		nat:        newUDPNAT(),
+		signal:     make(chan bool, 1),
+		method:     method,
+		password:   password,
+		running:    false,
+	}, nil
+}
+
+/* 在transport(例如WebSocket)上监听, 不支持UDP */
+func NewSSTransportRemoteTunnel(transport tconn.Transport, address, method, password string) (*SSRemoteTunnel, error) {
+	listener, err := tconn.NewSSTransportListener(transport, address, method, password)
+	if err != nil {
+		return nil, err
+	}
 	return &SSRemoteTunnel{
 		listener: listener,
+		nat:      newUDPNAT(),
 		signal:   make(chan bool, 1),
 		method:   method,
 		password: password,
@@ -52,40 +160,227 @@ func NewSSRemoteTunnel(address, method, password string) (*SSRemoteTunnel, error
 	}, nil
 }
 
+/* 多个用户共用一个端口, 用户可以在运行时添加和删除 */
+func NewSSMultiRemoteTunnel(address string, users map[string]tconn.SSUser) (*SSRemoteTunnel, error) {
+	return NewSSMultiTransportRemoteTunnel(tconn.TCPTransport, address, users)
+}
+
+/* 在transport上监听的多用户服务 */
+func NewSSMultiTransportRemoteTunnel(transport tconn.Transport, address string, users map[string]tconn.SSUser) (*SSRemoteTunnel, error) {
+	listener, err := tconn.NewSSMultiTransportListener(transport, address, users)
+	if err != nil {
+		return nil, err
+	}
+	return &SSRemoteTunnel{
+		listener: listener,
+		nat:      newUDPNAT(),
+		signal:   make(chan bool, 1),
+		running:  false,
+	}, nil
+}
+
+/* 用RSA私钥解密客户端发送的会话密钥 */
+func NewRSARemoteTunnel(address, method, privkeyFile string) (*SSRemoteTunnel, error) {
+	return NewRSATransportRemoteTunnel(tconn.TCPTransport, address, method, privkeyFile)
+}
+
+/* 在transport上监听的RSA服务 */
+func NewRSATransportRemoteTunnel(transport tconn.Transport, address, method, privkeyFile string) (*SSRemoteTunnel, error) {
+	key, err := cipher.LoadRSAFromFile(privkeyFile)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tconn.NewRSATransportListener(transport, address, method, key)
+	if err != nil {
+		return nil, err
+	}
+	return &SSRemoteTunnel{
+		listener: listener,
+		nat:      newUDPNAT(),
+		signal:   make(chan bool, 1),
+		method:   method,
+		running:  false,
+	}, nil
+}
+
 func (t *SSRemoteTunnel) runSSRemote(ssc *tconn.SSRConn) {
 	defer ssc.Close()
 	addr, port, err := ssc.Start()
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		logError(ssc.User(), err)
+		return
+	} else if ssc.Command() == socks.CMDBind {
+		t.runBind(ssc, addr, port)
+		return
+	} else if ssc.Command() == ss.CMDReverse {
+		t.runReverse(ssc, addr, port)
+		return
+	} else if ssc.Command() == ss.CMDMux {
+		t.runMux(ssc)
+		return
+	}
+	tc, baddr, bport, err := t.dial(addr, port)
+	if err != nil {
+		ssc.Reply(tconn.ReplyCode(err), "", 0)
+		logError(ssc.User(), err)
 		return
 	}
+	defer tc.Close()
+	if err := ssc.Reply(socks.ReplySuccess, baddr, bport); err != nil {
+		return
+	}
+	relay(ssc, tc)
+}
+
+/* 返回的地址是连接目标时使用的地址, 经由上游代理时是代理返回的地址 */
+func (t *SSRemoteTunnel) dial(addr string, port uint16) (*tconn.TConn, string, uint16, error) {
+	if t.proxy != nil {
+		cc, err := tconn.Socks5Dial(t.proxy, addr, port)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		baddr, bport := cc.BoundAddr()
+		return &cc.TConn, baddr, bport, nil
+	}
 	c, err := tconn.Dial("tcp", fmt.Sprintf("%s:%d", addr, port))
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		return nil, "", 0, err
+	}
+	bound := c.LocalAddr().(*net.TCPAddr)
+	return tconn.NewTConn(c), bound.IP.String(), uint16(bound.Port), nil
+}
+
+/*
+ * BIND, 在连接客户端的地址上监听一个端口
+ * 先把监听的地址发送给客户端, 有连接进来之后再发送对方的地址
+ * addr不是0.0.0.0时只接受来自这个地址的连接
+ */
+func (t *SSRemoteTunnel) runBind(ssc *tconn.SSRConn, addr string, port uint16) {
+	ip := ssc.LocalAddr().(*net.TCPAddr).IP
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		logError(ssc.User(), err)
 		return
 	}
-	tc := tconn.NewTConn(c)
+	defer l.Close()
+	bound := l.Addr().(*net.TCPAddr)
+	if err := ssc.WriteAddress(bound.IP.String(), uint16(bound.Port)); err != nil {
+		return
+	}
+
+	expected := net.ParseIP(addr)
+	l.SetDeadline(time.Now().Add(bindTimeout))
+	var c net.Conn
+	for {
+		if c, err = l.Accept(); err != nil {
+			logError(ssc.User(), err)
+			return
+		}
+		peer := c.RemoteAddr().(*net.TCPAddr)
+		if expected == nil || expected.IsUnspecified() || expected.Equal(peer.IP) {
+			break
+		}
+		c.Close()
+	}
+	tc := tconn.NewTConn(tconn.NewConn(c))
 	defer tc.Close()
-	c1 := make(chan []byte, 1024)
-	c2 := make(chan []byte, 1024)
-	go TConnChanel(ssc, c1)
-	go TConnChanel(tc, c2)
-LOOP:
+	peer := c.RemoteAddr().(*net.TCPAddr)
+	if err := ssc.WriteAddress(peer.IP.String(), uint16(peer.Port)); err != nil {
+		return
+	}
+	relay(ssc, tc)
+}
+
+/* 多路复用, 每个流连接各自的目标 */
+func (t *SSRemoteTunnel) runMux(ssc *tconn.SSRConn) {
+	session := tconn.NewMuxSession(ssc, false)
+	defer session.Close()
 	for {
-		select {
-		case data, ok := <-c1:
-			if !ok {
-				break LOOP
-			} else if err := tc.Write(data); err != nil {
-				break LOOP
-			}
-		case data, ok := <-c2:
-			if !ok {
-				break LOOP
-			} else if err := ssc.Write(data); err != nil {
-				break LOOP
+		stream, err := session.Accept()
+		if err != nil {
+			break
+		}
+		go t.runMuxStream(ssc.User(), stream)
+	}
+}
+
+func (t *SSRemoteTunnel) runMuxStream(user string, stream *tconn.MuxStream) {
+	defer stream.Close()
+	addr, port := stream.Addr()
+	tc, baddr, bport, err := t.dial(addr, port)
+	if err != nil {
+		stream.Reply(tconn.ReplyCode(err), "", 0)
+		logError(user, err)
+		return
+	}
+	defer tc.Close()
+	if err := stream.Reply(socks.ReplySuccess, baddr, bport); err != nil {
+		return
+	}
+	relay(stream, tc)
+}
+
+/*
+ * 反向隧道, 在允许的addr:port上监听, 控制连接关闭时停止监听
+ * 连接进来之后经由控制连接转发给客户端
+ */
+func (t *SSRemoteTunnel) runReverse(ssc *tconn.SSRConn, addr string, port uint16) {
+	if t.reverse == nil {
+		ssc.Reply(socks.ReplyConnectionNowAllowed, "", 0)
+		logError(ssc.User(), tconn.ErrNotAllowed)
+		return
+	}
+	baddr, err := t.reverse.check(addr, port)
+	if err != nil {
+		ssc.Reply(socks.ReplyConnectionNowAllowed, "", 0)
+		logError(ssc.User(), fmt.Errorf("Reverse %s:%d %v", addr, port, err))
+		return
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(baddr, strconv.Itoa(int(port))))
+	if err != nil {
+		ssc.Reply(tconn.ReplyCode(err), "", 0)
+		logError(ssc.User(), err)
+		return
+	}
+	defer l.Close()
+	bound := l.Addr().(*net.TCPAddr)
+	if err := ssc.Reply(socks.ReplySuccess, bound.IP.String(), uint16(bound.Port)); err != nil {
+		return
+	}
+
+	session := tconn.NewMuxSession(ssc, false)
+	defer session.Close()
+	go func() {
+		<-session.Done()
+		l.Close()
+	}()
+	/* 只有服务端打开流, 客户端打开的流直接关闭 */
+	go func() {
+		for {
+			stream, err := session.Accept()
+			if err != nil {
+				return
 			}
+			stream.Close()
+		}
+	}()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			break
+		}
+		peer := c.RemoteAddr().(*net.TCPAddr)
+		stream, err := session.Open(peer.IP.String(), uint16(peer.Port))
+		if err != nil {
+			c.Close()
+			break
 		}
+		go func() {
+			tc := tconn.NewTConn(tconn.NewConn(c))
+			defer tc.Close()
+			defer stream.Close()
+			relay(stream, tc)
+		}()
 	}
 }
 
@@ -96,11 +391,18 @@ func (t *SSRemoteTunnel) Quit() {
 func (t *SSRemoteTunnel) end() {
 	t.running = false
 	t.listener.Close()
+	if t.packetConn != nil {
+		t.packetConn.Close()
+		t.nat.close()
+	}
 }
 
 func (t *SSRemoteTunnel) Run() {
 	defer t.end()
 	t.running = true
+	if t.packetConn != nil {
+		go t.runUDP()
+	}
 
 	cc := make(chan *tconn.SSRConn, 128)
 	go func() {
@@ -0,0 +1,215 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * Shadowsocks UDP转发的NAT表
+ * 每个客户端地址对应一个发往目标的UDP socket, 超过idle时间没有数据时关闭
+ */
+
+const (
+	defaultUDPTimeout      = 60 * time.Second
+	defaultUDPAssociations = 1024
+	/* 每个客户端等待发送的数据包, 超过时丢弃 */
+	udpSendQueueSize = 64
+	/* 每个客户端缓存的目标地址数量, 超过时清空 */
+	udpResolveCacheSize = 64
+)
+
+type udpAssociation struct {
+	conn    net.PacketConn
+	client  net.Addr
+	lock    sync.Mutex
+	active  time.Time
+	packets chan *ss.AddressRequest
+	done    chan struct{}
+}
+
+func (a *udpAssociation) touch() {
+	a.lock.Lock()
+	a.active = time.Now()
+	a.lock.Unlock()
+}
+
+func (a *udpAssociation) idle() time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return time.Since(a.active)
+}
+
+type udpNAT struct {
+	lock    sync.Mutex
+	entries map[string]*udpAssociation
+	timeout time.Duration
+	limit   int
+}
+
+func newUDPNAT() *udpNAT {
+	return &udpNAT{
+		entries: make(map[string]*udpAssociation),
+		timeout: defaultUDPTimeout,
+		limit:   defaultUDPAssociations,
+	}
+}
+
+func (nat *udpNAT) setTimeout(timeout time.Duration) {
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+	nat.timeout = timeout
+}
+
+func (nat *udpNAT) setLimit(limit int) {
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+	nat.limit = limit
+}
+
+func (nat *udpNAT) idleTimeout() time.Duration {
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+	return nat.timeout
+}
+
+/* 得到客户端对应的socket, 超过数量限制时返回nil */
+func (nat *udpNAT) get(pc *tconn.SSPacketConn, client net.Addr) *udpAssociation {
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+	key := client.String()
+	if a := nat.entries[key]; a != nil {
+		return a
+	} else if len(nat.entries) >= nat.limit {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", "")
+	if err != nil {
+		return nil
+	}
+	a := &udpAssociation{
+		conn:    conn,
+		client:  client,
+		active:  time.Now(),
+		packets: make(chan *ss.AddressRequest, udpSendQueueSize),
+		done:    make(chan struct{}),
+	}
+	nat.entries[key] = a
+	go nat.relay(pc, a, key)
+	go a.send()
+	return a
+}
+
+/* 放入发送队列, 队列满时丢弃 */
+func (a *udpAssociation) push(req *ss.AddressRequest) {
+	select {
+	case a.packets <- req:
+	default:
+	}
+}
+
+/* 解析目标地址并发送, 域名解析不会阻塞其他客户端 */
+func (a *udpAssociation) send() {
+	cache := make(map[string]*net.UDPAddr)
+	for {
+		var req *ss.AddressRequest
+		select {
+		case req = <-a.packets:
+		case <-a.done:
+			return
+		}
+		key := net.JoinHostPort(req.ADDR, strconv.Itoa(int(req.PORT)))
+		target := cache[key]
+		if target == nil {
+			var err error
+			if target, err = net.ResolveUDPAddr("udp", key); err != nil {
+				continue
+			} else if len(cache) >= udpResolveCacheSize {
+				cache = make(map[string]*net.UDPAddr)
+			}
+			cache[key] = target
+		}
+		a.conn.WriteTo(req.BUF, target)
+	}
+}
+
+/* 把目标的回复发送给客户端, 空闲超时后删除 */
+func (nat *udpNAT) relay(pc *tconn.SSPacketConn, a *udpAssociation, key string) {
+	defer func() {
+		nat.lock.Lock()
+		delete(nat.entries, key)
+		nat.lock.Unlock()
+		a.conn.Close()
+		close(a.done)
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		a.conn.SetReadDeadline(time.Now().Add(nat.idleTimeout() - a.idle()))
+		n, from, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() && a.idle() < nat.idleTimeout() {
+				continue
+			}
+			break
+		}
+		a.touch()
+		addr := from.(*net.UDPAddr)
+		req := ss.NewAddressRequest(socks.GetAddrAType(addr.IP.String()), addr.IP.String(), uint16(addr.Port))
+		req.BUF = buf[:n]
+		if err := pc.WriteTo(req, a.client); err != nil {
+			break
+		}
+	}
+}
+
+func (nat *udpNAT) close() {
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+	for _, a := range nat.entries {
+		a.conn.Close()
+	}
+}
+
+func (nat *udpNAT) count() int {
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+	return len(nat.entries)
+}
+
+/* 解密客户端的数据包, 转发给目标 */
+func (t *SSRemoteTunnel) runUDP() {
+	for {
+		req, from, err := t.packetConn.ReadFrom()
+		if err != nil {
+			break
+		}
+		a := t.nat.get(t.packetConn, from)
+		if a == nil {
+			continue
+		}
+		a.touch()
+		a.push(req)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startUDPEcho(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn
+}
+
+func TestSSRemoteUDP(t *testing.T) {
+	echo := startUDPEcho(t)
+	defer echo.Close()
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.SetUDPTimeout(200 * time.Millisecond)
+	remote.SetUDPLimit(1)
+	go remote.Run()
+	defer remote.Quit()
+	port := uint16(remote.packetConn.LocalAddr().(*net.UDPAddr).Port)
+
+	req := ss.NewAddressRequest(socks.ATypeIPv4, "127.0.0.1", uint16(echoAddr.Port))
+	req.BUF = []byte("hello")
+	clients := make([]*tconn.SSPacketConn, 2)
+	replies := make(chan int, 2)
+	for i := range clients {
+		c, err := tconn.DialSSPacket("127.0.0.1", port, "aes-256-gcm", "galaxy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients[i] = c
+		go func(i int) {
+			if reply, err := c.Read(); err == nil && string(reply.BUF) == "hello" && reply.PORT == uint16(echoAddr.Port) {
+				replies <- i
+			}
+		}(i)
+	}
+
+	clients[0].Write(req)
+	if i := <-replies; i != 0 {
+		t.Fatal("Wrong Client")
+	}
+	/* 超过数量限制的数据包被丢弃 */
+	clients[1].Write(req)
+	select {
+	case <-replies:
+		t.Fatal("Association Limit Exceeded")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	/* 空闲超时之后释放 */
+	time.Sleep(300 * time.Millisecond)
+	if n := remote.nat.count(); n != 0 {
+		t.Fatalf("%d Associations Not Expired", n)
+	}
+	clients[1].Write(req)
+	select {
+	case i := <-replies:
+		if i != 1 {
+			t.Fatal("Wrong Client")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No Reply After Expiration")
+	}
+}
+
+/* 运行中修改超时时间, 之后建立的转发使用新的设置 */
+func TestSSRemoteUDPSetTimeout(t *testing.T) {
+	echo := startUDPEcho(t)
+	defer echo.Close()
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	remote, err := NewSSRemoteTunnel("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go remote.Run()
+	defer remote.Quit()
+	port := uint16(remote.packetConn.LocalAddr().(*net.UDPAddr).Port)
+
+	req := ss.NewAddressRequest(socks.ATypeIPv4, "127.0.0.1", uint16(echoAddr.Port))
+	req.BUF = []byte("hello")
+	echoOnce := func() {
+		c, err := tconn.DialSSPacket("127.0.0.1", port, "aes-256-gcm", "galaxy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Write(req)
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	echoOnce()
+	remote.SetUDPTimeout(100 * time.Millisecond)
+	remote.SetUDPLimit(8)
+	echoOnce()
+	if n := remote.nat.count(); n != 2 {
+		t.Fatalf("%d Associations", n)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := remote.nat.count(); n != 1 {
+		t.Fatalf("%d Associations After Timeout", n)
+	}
+}
+
+/* 经由本地隧道的UDP ASSOCIATE, 控制连接关闭时结束 */
+func TestSSLocalUDPAssociate(t *testing.T) {
+	echo := startUDPEcho(t)
+	defer echo.Close()
+	echoPort := uint16(echo.LocalAddr().(*net.UDPAddr).Port)
+	local, remote := newSSTunnels(t, "aes-256-gcm", "galaxy")
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build())
+	io.ReadFull(c, make([]byte, 2))
+	c.Write(socks.NewSocks5Request(socks.Version5, socks.CMDUDPAssociate, socks.ATypeIPv4, "0.0.0.0", 0).Build())
+	rep, err := socks.ReadSocks5Reply(c)
+	if err != nil {
+		t.Fatal(err)
+	} else if rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	} else if rep.ADDR != "127.0.0.1" || rep.PORT == 0 {
+		t.Fatalf("Wrong Relay Address %s:%d", rep.ADDR, rep.PORT)
+	}
+	relayAddr := &net.UDPAddr{IP: net.ParseIP(rep.ADDR), Port: int(rep.PORT)}
+	msg := socks.NewSOCKSUDPMessage(0, socks.ATypeIPv4, "127.0.0.1", echoPort, []byte("hello")).Build()
+
+	uc, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	buf := make([]byte, 2048)
+	uc.Write(msg)
+	uc.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := uc.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := socks.ParseSocks5UDPMessage(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	} else if string(reply.DATA) != "hello" || reply.ADDR != "127.0.0.1" || reply.PORT != echoPort {
+		t.Fatalf("Wrong Reply %s:%d %q", reply.ADDR, reply.PORT, reply.DATA)
+	}
+
+	/* 不是控制连接客户端IP的数据包被丢弃 */
+	foreign, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer foreign.Close()
+	foreign.WriteToUDP(msg, relayAddr)
+	foreign.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := foreign.ReadFromUDP(buf); err == nil {
+		t.Fatal("Foreign Datagram Relayed")
+	}
+
+	/* 控制连接关闭之后中继端口被关闭 */
+	c.Close()
+	for i := 0; ; i++ {
+		uc.Write(msg)
+		uc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := uc.Read(buf)
+		if e, ok := err.(net.Error); err != nil && !(ok && e.Timeout()) {
+			break
+		} else if i == 20 {
+			t.Fatal("Association Not Closed")
+		}
+	}
+}
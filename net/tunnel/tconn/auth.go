@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"galaxy/cipher/bcrypt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * SOCKS5用户名密码认证, 验证通过后用户名作为连接的用户标识
+ */
+type Authenticator interface {
+	Authenticate(uname, passwd string) bool
+}
+
+/* 先计算摘要再比较, 比较的时间和长度无关 */
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+/* 用户名和密码保存在内存中, 可以在运行时修改 */
+type MapAuthenticator struct {
+	lock  sync.RWMutex
+	users map[string]string
+}
+
+func NewMapAuthenticator(users map[string]string) *MapAuthenticator {
+	auth := &MapAuthenticator{
+		users: make(map[string]string),
+	}
+	for uname, passwd := range users {
+		auth.users[uname] = passwd
+	}
+	return auth
+}
+
+func (a *MapAuthenticator) SetUser(uname, passwd string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.users[uname] = passwd
+}
+
+func (a *MapAuthenticator) RemoveUser(uname string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.users, uname)
+}
+
+func (a *MapAuthenticator) Authenticate(uname, passwd string) bool {
+	a.lock.RLock()
+	expected, ok := a.users[uname]
+	a.lock.RUnlock()
+	/* 用户不存在时也做一次比较 */
+	return secureCompare(expected, passwd) && ok
+}
+
+const (
+	/* 两次检查文件是否修改的最小间隔 */
+	htpasswdCheckInterval = time.Second
+)
+
+/*
+ * htpasswd格式的文件, 每行是 用户名:bcrypt哈希, #开头的行是注释
+ * 文件修改之后自动重新加载, 加载失败时继续使用之前的内容
+ */
+type HtpasswdAuthenticator struct {
+	lock    sync.Mutex
+	path    string
+	users   map[string][]byte
+	modTime time.Time
+	size    int64
+	checked time.Time
+	dummy   []byte
+}
+
+func NewHtpasswdAuthenticator(path string) (*HtpasswdAuthenticator, error) {
+	a := &HtpasswdAuthenticator{
+		path: path,
+	}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("galaxy"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	a.dummy = dummy
+	return a, nil
+}
+
+func parseHtpasswd(data []byte) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid Htpasswd Line %d", n)
+		}
+		hash := line[i+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("Invalid Bcrypt Hash At Line %d", n)
+		}
+		users[line[:i]] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
+
+func (a *HtpasswdAuthenticator) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.checked = time.Now()
+	if a.users != nil && info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return nil
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return err
+	}
+	a.users = users
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+	return nil
+}
+
+func (a *HtpasswdAuthenticator) lookup(uname string) ([]byte, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if time.Since(a.checked) >= htpasswdCheckInterval {
+		if err := a.reload(); err != nil {
+			fmt.Printf("%s: %v\n", a.path, err)
+		}
+	}
+	hash, ok := a.users[uname]
+	return hash, ok
+}
+
+func (a *HtpasswdAuthenticator) Authenticate(uname, passwd string) bool {
+	hash, ok := a.lookup(uname)
+	if !ok {
+		/* 用户不存在时也计算一次哈希 */
+		bcrypt.CompareHashAndPassword(a.dummy, []byte(passwd))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(passwd)) == nil
+}
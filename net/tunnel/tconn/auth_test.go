@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"galaxy/cipher/bcrypt"
+	"galaxy/protocol/socks"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMapAuthenticator(t *testing.T) {
+	auth := NewMapAuthenticator(map[string]string{"alice": "secret"})
+	if !auth.Authenticate("alice", "secret") {
+		t.Fatal("Valid User Rejected")
+	} else if auth.Authenticate("alice", "secret1") || auth.Authenticate("bob", "") {
+		t.Fatal("Invalid User Accepted")
+	}
+	auth.SetUser("bob", "password")
+	auth.RemoveUser("alice")
+	if !auth.Authenticate("bob", "password") || auth.Authenticate("alice", "secret") {
+		t.Fatal("Users Not Updated")
+	}
+}
+
+func writeHtpasswd(t *testing.T, path string, users map[string]string) {
+	data := "# galaxy\n"
+	for uname, passwd := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data += uname + ":" + string(hash) + "\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeHtpasswd(t, path, map[string]string{"alice": "secret"})
+	auth, err := NewHtpasswdAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.Authenticate("alice", "secret") {
+		t.Fatal("Valid User Rejected")
+	} else if auth.Authenticate("alice", "wrong") || auth.Authenticate("bob", "secret") {
+		t.Fatal("Invalid User Accepted")
+	}
+
+	/* 修改文件之后重新加载 */
+	writeHtpasswd(t, path, map[string]string{"alice": "secret", "bob": "password"})
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	auth.checked = time.Time{}
+	if !auth.Authenticate("bob", "password") {
+		t.Fatal("File Not Reloaded")
+	}
+
+	/* 文件格式错误时保留之前的用户 */
+	os.WriteFile(path, []byte("bob:plaintext\n"), 0600)
+	auth.checked = time.Time{}
+	if !auth.Authenticate("bob", "password") {
+		t.Fatal("Users Lost After Invalid File")
+	}
+	if _, err := NewHtpasswdAuthenticator(path); err == nil {
+		t.Fatal("Invalid File Accepted")
+	}
+}
+
+func TestSocks5Authenticator(t *testing.T) {
+	l, err := NewSocks5Listener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.SetAuthenticator(NewMapAuthenticator(map[string]string{"alice": "secret"}))
+
+	var data []byte
+	data = append(data, socks.NewMethodSelectionRequest(socks.Version5, socks.MethodUsernamePassword).Build()...)
+	data = append(data, socks.NewUsernamePasswordRequest(socks.UsernamePasswordVersion, "alice", "secret").Build()...)
+	data = append(data, socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.ATypeIPv4, "127.0.0.1", 80).Build()...)
+	c := testSendHandshake(t, l.Addr().String(), data, false)
+	defer c.Close()
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	if _, _, err := sc.Start(); err != nil {
+		t.Fatal(err)
+	} else if sc.User() != "alice" {
+		t.Fatalf("Wrong User %s", sc.User())
+	}
+}
@@ -0,0 +1,316 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	_cipher "crypto/cipher"
+	"encoding/binary"
+	"galaxy/cipher"
+	"galaxy/protocol/ss"
+	"io"
+)
+
+/*
+ * Shadowsocks的数据加解密
+ * 流加密: [IV][加密数据...]
+ * AEAD加密: [salt][加密长度][长度TAG][加密数据][数据TAG]...
+ * SS2022加密见ss2022.go
+ */
+
+const (
+	/* AEAD每个块的最大长度 */
+	aeadMaxPayloadSize = 0x3FFF
+)
+
+type ssWriter interface {
+	Write(w io.Writer, data []byte) error
+}
+
+type ssReader interface {
+	Read(r io.Reader) ([]byte, error)
+}
+
+/* 流加密没有认证, 服务端解析出第一个请求之后才检查并记录IV, 避免随机数据填满过滤器 */
+type saltConfirmer interface {
+	confirmSalt() error
+}
+
+/* 创建一个连接的读写器, 服务端需要提供防重放的salt过滤器 */
+func newSSReadWriter(info *cipher.CipherInfo, key []byte, server bool, filter saltFilter) (ssReader, ssWriter, error) {
+	if info.SS2022 {
+		return newSS2022ReadWriter(info, key, server, filter)
+	}
+	writer, err := newSSWriter(info, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newSSReader(info, key, filter), writer, nil
+}
+
+/* 根据密码得到主密钥, SS2022的密码是base64编码的PSK */
+func createSSKey(info *cipher.CipherInfo, password string) ([]byte, error) {
+	if info.SS2022 {
+		return ss.DecodePSK(password, info.KeySize)
+	}
+	return ss.CreateKey(password, info.KeySize), nil
+}
+
+func newSSWriter(info *cipher.CipherInfo, key []byte) (ssWriter, error) {
+	if info.IsAEAD() {
+		salt := cipher.RandKey(info.IvSize)
+		aead, err := info.NewAEAD(key, salt)
+		if err != nil {
+			return nil, err
+		}
+		return &aeadWriter{
+			aead:  aead,
+			salt:  salt,
+			nonce: make([]byte, aead.NonceSize()),
+		}, nil
+	}
+	iv := cipher.RandKey(info.IvSize)
+	encrypter, err := info.EncrypterFunc(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		encrypter: encrypter,
+		iv:        iv,
+	}, nil
+}
+
+/* filter为nil时不检查IV/salt是否重复 */
+func newSSReader(info *cipher.CipherInfo, key []byte, filter saltFilter) ssReader {
+	if info.IsAEAD() {
+		return &aeadReader{
+			info:   info,
+			key:    key,
+			filter: filter,
+		}
+	}
+	return &streamReader{
+		info:   info,
+		key:    key,
+		filter: filter,
+	}
+}
+
+/* IV/salt已经通过其他方式协商好, 不在数据中发送 */
+func newSSPresetWriter(info *cipher.CipherInfo, key, iv []byte) (ssWriter, error) {
+	if info.IsAEAD() {
+		aead, err := info.NewAEAD(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		return &aeadWriter{
+			aead:     aead,
+			salt:     iv,
+			saltSent: true,
+			nonce:    make([]byte, aead.NonceSize()),
+		}, nil
+	}
+	encrypter, err := info.EncrypterFunc(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		encrypter: encrypter,
+		iv:        iv,
+		ivSent:    true,
+	}, nil
+}
+
+func newSSPresetReader(info *cipher.CipherInfo, key, iv []byte) (ssReader, error) {
+	if info.IsAEAD() {
+		aead, err := info.NewAEAD(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		return &aeadReader{
+			info:  info,
+			key:   key,
+			aead:  aead,
+			nonce: make([]byte, aead.NonceSize()),
+		}, nil
+	}
+	decrypter, err := info.DecrypterFunc(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{
+		info:      info,
+		key:       key,
+		decrypter: decrypter,
+	}, nil
+}
+
+/* 流加密 */
+type streamWriter struct {
+	encrypter cipher.Encrypter
+	iv        []byte
+	ivSent    bool
+}
+
+func (sw *streamWriter) Write(w io.Writer, data []byte) error {
+	data = sw.encrypter.Encrypt(data)
+	if !sw.ivSent {
+		sw.ivSent = true
+		data = append(append([]byte{}, sw.iv...), data...)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+type streamReader struct {
+	info      *cipher.CipherInfo
+	key       []byte
+	decrypter cipher.Decrypter
+	filter    saltFilter
+	iv        []byte
+}
+
+func (sr *streamReader) Read(r io.Reader) ([]byte, error) {
+	if sr.decrypter == nil {
+		iv := make([]byte, sr.info.IvSize)
+		if _, err := io.ReadFull(r, iv); err != nil {
+			return nil, err
+		}
+		decrypter, err := sr.info.DecrypterFunc(sr.key, iv)
+		if err != nil {
+			return nil, err
+		}
+		sr.decrypter = decrypter
+		/* none和not没有IV, 不检查重放 */
+		if sr.filter != nil && len(iv) > 0 {
+			sr.iv = iv
+		}
+	}
+	buf := make([]byte, 4096)
+	n, err := r.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return sr.decrypter.Decrypt(buf[:n]), nil
+}
+
+func (sr *streamReader) confirmSalt() error {
+	if sr.iv != nil {
+		iv := sr.iv
+		sr.iv = nil
+		if !sr.filter.Check(iv) {
+			return errSaltReplayed
+		}
+	}
+	return nil
+}
+
+/* AEAD加密 */
+type aeadWriter struct {
+	aead     _cipher.AEAD
+	salt     []byte
+	saltSent bool
+	nonce    []byte
+}
+
+func (aw *aeadWriter) Write(w io.Writer, data []byte) error {
+	var buf []byte
+	if !aw.saltSent {
+		aw.saltSent = true
+		buf = append(buf, aw.salt...)
+	}
+	for len(data) > 0 {
+		size := len(data)
+		if size > aeadMaxPayloadSize {
+			size = aeadMaxPayloadSize
+		}
+		var sizebuf [2]byte
+		binary.BigEndian.PutUint16(sizebuf[:], uint16(size))
+		buf = aw.aead.Seal(buf, aw.nonce, sizebuf[:], nil)
+		increaseNonce(aw.nonce)
+		buf = aw.aead.Seal(buf, aw.nonce, data[:size], nil)
+		increaseNonce(aw.nonce)
+		data = data[size:]
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+type aeadReader struct {
+	info   *cipher.CipherInfo
+	key    []byte
+	aead   _cipher.AEAD
+	nonce  []byte
+	salt   []byte
+	filter saltFilter
+}
+
+func (ar *aeadReader) Read(r io.Reader) ([]byte, error) {
+	if ar.aead == nil {
+		salt := make([]byte, ar.info.IvSize)
+		if _, err := io.ReadFull(r, salt); err != nil {
+			return nil, err
+		}
+		aead, err := ar.info.NewAEAD(ar.key, salt)
+		if err != nil {
+			return nil, err
+		}
+		ar.aead = aead
+		ar.nonce = make([]byte, aead.NonceSize())
+		ar.salt = salt
+	}
+	overhead := ar.aead.Overhead()
+	buf := make([]byte, 2+overhead)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	sizebuf, err := ar.aead.Open(buf[:0], ar.nonce, buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	/* 第一个块认证通过之后再记录salt, 避免被随机数据填满 */
+	if ar.salt != nil {
+		salt := ar.salt
+		ar.salt = nil
+		if ar.filter != nil && !ar.filter.Check(salt) {
+			return nil, errSaltReplayed
+		}
+	}
+	increaseNonce(ar.nonce)
+
+	size := int(binary.BigEndian.Uint16(sizebuf)) & aeadMaxPayloadSize
+	buf = make([]byte, size+overhead)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	data, err := ar.aead.Open(buf[:0], ar.nonce, buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	increaseNonce(ar.nonce)
+	return data, nil
+}
+
+/* nonce按小端序加1 */
+func increaseNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"galaxy/cipher"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"testing"
+)
+
+func testSSCipher(t *testing.T, method string) {
+	info := cipher.GetCipherInfo(method)
+	key := ss.CreateKey("galaxy", info.KeySize)
+	writer, err := newSSWriter(info, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := newSSReader(info, key, nil)
+
+	plain := bytes.Repeat([]byte("0123456789"), 4000)
+	buf := bytes.Buffer{}
+	if err := writer.Write(&buf, append([]byte{}, plain[:10]...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(&buf, append([]byte{}, plain[10:]...)); err != nil {
+		t.Fatal(err)
+	}
+	var result []byte
+	for len(result) < len(plain) {
+		data, err := reader.Read(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		result = append(result, data...)
+	}
+	if !bytes.Equal(result, plain) {
+		t.Fatalf("%s: Wrong Plaintext", method)
+	}
+}
+
+func TestSSCipher(t *testing.T) {
+	testSSCipher(t, "aes-256-cfb")
+	testSSCipher(t, "aes-128-gcm")
+	testSSCipher(t, "aes-256-gcm")
+	testSSCipher(t, "chacha20-ietf-poly1305")
+	testSSCipher(t, "xchacha20-ietf-poly1305")
+}
+
+func TestSSCipherTampered(t *testing.T) {
+	info := cipher.GetCipherInfo("aes-256-gcm")
+	key := ss.CreateKey("galaxy", info.KeySize)
+	writer, _ := newSSWriter(info, key)
+	buf := bytes.Buffer{}
+	writer.Write(&buf, []byte("hello"))
+	data := buf.Bytes()
+	data[len(data)-1] ^= 1
+	if _, err := newSSReader(info, key, nil).Read(bytes.NewReader(data)); err == nil {
+		t.Fatal("Tampered Chunk Accepted")
+	}
+}
+
+func testSS2022(t *testing.T, method string) {
+	info := cipher.GetCipherInfo(method)
+	key := cipher.RandKey(info.KeySize)
+	pool := newSS2022SaltPool()
+	creader, cwriter, err := newSSReadWriter(info, key, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sreader, swriter, err := newSSReadWriter(info, key, true, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* 请求: 目标地址之后是数据 */
+	addr := ss.NewAddressRequest(socks.ATypeDomain, "example.com", 443).Build()
+	plain := bytes.Repeat([]byte("0123456789"), 8000)
+	request := bytes.Buffer{}
+	cwriter.Write(&request, addr)
+	cwriter.Write(&request, plain)
+	raw := append([]byte{}, request.Bytes()...)
+
+	data, err := sreader.Read(&request)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	} else if !bytes.Equal(data, addr) {
+		t.Fatalf("%s: Wrong Address", method)
+	}
+	var result []byte
+	for len(result) < len(plain) {
+		data, err := sreader.Read(&request)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		result = append(result, data...)
+	}
+	if !bytes.Equal(result, plain) {
+		t.Fatalf("%s: Wrong Request", method)
+	}
+
+	/* 响应 */
+	response := bytes.Buffer{}
+	swriter.Write(&response, plain)
+	result = nil
+	for len(result) < len(plain) {
+		data, err := creader.Read(&response)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		result = append(result, data...)
+	}
+	if !bytes.Equal(result, plain) {
+		t.Fatalf("%s: Wrong Response", method)
+	}
+
+	/* 重放的请求 */
+	replayReader, _, _ := newSSReadWriter(info, key, true, pool)
+	if _, err := replayReader.Read(bytes.NewReader(raw)); err != errSaltReplayed {
+		t.Fatalf("%s: Replayed Request Accepted", method)
+	}
+
+	/* 响应中的salt不是自己发送的 */
+	otherReader, otherWriter, _ := newSSReadWriter(info, key, false, nil)
+	otherWriter.Write(&bytes.Buffer{}, addr)
+	response.Reset()
+	_, swriter, _ = newSSReadWriter(info, key, true, pool)
+	swriter.(*ss2022Writer).reader = sreader.(*ss2022Reader)
+	swriter.Write(&response, []byte("hello"))
+	if _, err := otherReader.Read(&response); err != errSS2022BadSalt {
+		t.Fatalf("%s: Wrong Request Salt Accepted", method)
+	}
+}
+
+func TestSS2022(t *testing.T) {
+	testSS2022(t, "2022-blake3-aes-128-gcm")
+	testSS2022(t, "2022-blake3-aes-256-gcm")
+	testSS2022(t, "2022-blake3-chacha20-poly1305")
+}
+
+func TestSS2022Timestamp(t *testing.T) {
+	buf := ss2022Timestamp()
+	if !ss2022CheckTimestamp(buf) {
+		t.Fatal("Valid Timestamp Rejected")
+	}
+	binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(buf)-ss2022MaxTimeDiff-5)
+	if ss2022CheckTimestamp(buf) {
+		t.Fatal("Expired Timestamp Accepted")
+	}
+}
@@ -82,7 +82,25 @@ func (t *TConn) Close() {
 	defer t.conn.Close()
 }
 
+func (t *TConn) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *TConn) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
 type IConn interface {
 	Read() ([]byte, error)
 	Write([]byte) error
 }
+
+/* 本地代理(SOCKS或者HTTP)的客户端连接, Start返回目标地址, Reply回复连接的结果 */
+type ProxyConn interface {
+	IConn
+	Close()
+	Start() (string, uint16, error)
+	Command() byte
+	User() string
+	Reply(rep byte, addr string, port uint16) error
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"galaxy/protocol/socks"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errHTTPAuth       = errors.New("Proxy Authentication Required")
+	errHTTPBadRequest = errors.New("Bad HTTP Proxy Request")
+)
+
+type HTTPListener struct {
+	netListener net.Listener
+	auth        Authenticator
+}
+
+/*
+ * HTTP Proxy Server Conn
+ * CONNECT之外的请求改写成origin-form之后作为第一段数据发送, 每个连接只转发一个请求
+ */
+type HTTPSConn struct {
+	TConn
+	auth    Authenticator
+	user    string
+	connect bool
+	reqBuf  []byte
+}
+
+func NewHTTPListener(address string) (*HTTPListener, error) {
+	netListener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPListener{
+		netListener: netListener,
+	}, nil
+}
+
+func (l *HTTPListener) Close() {
+	defer l.netListener.Close()
+}
+
+func (l *HTTPListener) Addr() net.Addr {
+	return l.netListener.Addr()
+}
+
+/* auth为nil时不需要认证, 在Accept之前设置 */
+func (l *HTTPListener) SetAuthenticator(auth Authenticator) error {
+	l.auth = auth
+	return nil
+}
+
+func (l *HTTPListener) Accept() (*HTTPSConn, error) {
+	netConn, err := l.netListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPSConn{
+		TConn: TConn{
+			conn: NewConn(netConn),
+		},
+		auth: l.auth,
+	}, nil
+}
+
+/* 认证通过的用户名, 不需要认证时为空 */
+func (hc *HTTPSConn) User() string {
+	return hc.user
+}
+
+/* HTTP代理只有CONNECT */
+func (hc *HTTPSConn) Command() byte {
+	return socks.CMDConnect
+}
+
+func (hc *HTTPSConn) writeStatus(code int, header string) error {
+	_, err := fmt.Fprintf(hc.conn, "HTTP/1.1 %d %s\r\n%sContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code), header)
+	return err
+}
+
+/* Proxy-Authorization: Basic base64(user:password) */
+func (hc *HTTPSConn) authenticate(req *http.Request) bool {
+	value := req.Header.Get("Proxy-Authorization")
+	if len(value) < 6 || !strings.EqualFold(value[:6], "Basic ") {
+		return false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[6:]))
+	if err != nil {
+		return false
+	}
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return false
+	}
+	uname := string(data[:i])
+	if !hc.auth.Authenticate(uname, string(data[i+1:])) {
+		return false
+	}
+	hc.user = uname
+	return true
+}
+
+func splitHostPort(hostport string, defaultPort uint16) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		/* 没有端口 */
+		host = strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+		if host == "" {
+			return "", 0, errHTTPBadRequest
+		}
+		return host, defaultPort, nil
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || host == "" {
+		return "", 0, errHTTPBadRequest
+	}
+	return host, uint16(port), nil
+}
+
+/* 改写成origin-form, 去掉代理相关的头 */
+func buildOriginRequest(req *http.Request) []byte {
+	header := req.Header.Clone()
+	header.Del("Proxy-Authorization")
+	header.Del("Proxy-Connection")
+	if header.Get("Upgrade") == "" {
+		header.Del("Keep-Alive")
+		header.Set("Connection", "close")
+	}
+	if len(req.TransferEncoding) > 0 {
+		header.Set("Transfer-Encoding", strings.Join(req.TransferEncoding, ", "))
+	}
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "%s %s HTTP/%d.%d\r\n", req.Method, req.URL.RequestURI(), req.ProtoMajor, req.ProtoMinor)
+	fmt.Fprintf(&buf, "Host: %s\r\n", req.Host)
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+/*
+ * 读取代理请求, 返回目标地址
+ * 请求之后已经读到的数据(包括请求的body)留给Read
+ */
+func (hc *HTTPSConn) Start() (string, uint16, error) {
+	reader := bufio.NewReader(hc.conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return "", 0, err
+	}
+	if hc.auth != nil && !hc.authenticate(req) {
+		hc.writeStatus(http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"galaxy\"\r\n")
+		return "", 0, errHTTPAuth
+	}
+
+	var addr string
+	var port uint16
+	if req.Method == http.MethodConnect {
+		hc.connect = true
+		addr, port, err = splitHostPort(req.RequestURI, 443)
+	} else if req.URL.IsAbs() && req.URL.Scheme == "http" {
+		addr, port, err = splitHostPort(req.URL.Host, 80)
+		hc.reqBuf = buildOriginRequest(req)
+	} else {
+		err = errHTTPBadRequest
+	}
+	if err != nil {
+		hc.writeStatus(http.StatusBadRequest, "")
+		return "", 0, err
+	}
+	if n := reader.Buffered(); n > 0 {
+		buf, _ := reader.Peek(n)
+		hc.reqBuf = append(hc.reqBuf, buf...)
+	}
+	return addr, port, nil
+}
+
+/*
+ * 和SOCKS5的回复码对应, 地址没有用到
+ * 成功时CONNECT回复200, 其他请求由目标服务器回复
+ */
+func (hc *HTTPSConn) Reply(rep byte, addr string, port uint16) error {
+	switch rep {
+	case socks.ReplySuccess:
+		if !hc.connect {
+			return nil
+		}
+		_, err := fmt.Fprintf(hc.conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		return err
+	case socks.ReplyConnectionNowAllowed:
+		return hc.writeStatus(http.StatusForbidden, "")
+	case socks.ReplyTTLExpired:
+		return hc.writeStatus(http.StatusGatewayTimeout, "")
+	}
+	return hc.writeStatus(http.StatusBadGateway, "")
+}
+
+func (hc *HTTPSConn) Read() ([]byte, error) {
+	if len(hc.reqBuf) != 0 {
+		buf := hc.reqBuf
+		hc.reqBuf = nil
+		return buf, nil
+	}
+	return hc.TConn.Read()
+}
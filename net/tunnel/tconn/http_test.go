@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bufio"
+	"encoding/base64"
+	"galaxy/protocol/socks"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testHTTPStart(t *testing.T, auth Authenticator, request string) (*HTTPSConn, net.Conn, string, uint16, error) {
+	l, err := NewHTTPListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.SetAuthenticator(auth)
+	c := testSendHandshake(t, l.Addr().String(), []byte(request), true)
+	hc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, port, err := hc.Start()
+	return hc, c, addr, port, err
+}
+
+func TestHTTPConnect(t *testing.T) {
+	hc, c, addr, port, err := testHTTPStart(t, nil, "CONNECT [::1]:8443 HTTP/1.1\r\nHost: [::1]:8443\r\n\r\nearly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	defer hc.Close()
+	if addr != "::1" || port != 8443 {
+		t.Fatalf("Wrong Address %s:%d", addr, port)
+	}
+	if buf := testReadAll(t, hc, 5); string(buf) != "early" {
+		t.Fatalf("Wrong Early Data %q", buf)
+	}
+	hc.Reply(socks.ReplySuccess, "", 0)
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPForward(t *testing.T) {
+	request := "POST http://www.baidu.com/s?wd=galaxy HTTP/1.1\r\n" +
+		"Host: www.baidu.com\r\n" +
+		"Proxy-Connection: keep-alive\r\n" +
+		"Content-Length: 4\r\n\r\n" +
+		"body"
+	hc, c, addr, port, err := testHTTPStart(t, nil, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	defer hc.Close()
+	if addr != "www.baidu.com" || port != 80 {
+		t.Fatalf("Wrong Address %s:%d", addr, port)
+	}
+	data := string(testReadAll(t, hc, 1))
+	for !strings.HasSuffix(data, "body") {
+		data += string(testReadAll(t, hc, 1))
+	}
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	} else if req.RequestURI != "/s?wd=galaxy" || req.Host != "www.baidu.com" {
+		t.Fatalf("Wrong Request %s %s", req.Host, req.RequestURI)
+	} else if req.Header.Get("Proxy-Connection") != "" || !req.Close {
+		t.Fatal("Proxy Headers Not Removed")
+	} else if req.ContentLength != 4 {
+		t.Fatal("Wrong Content-Length")
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	auth := NewMapAuthenticator(map[string]string{"alice": "secret"})
+	hc, c, _, _, err := testHTTPStart(t, auth, "CONNECT www.baidu.com:443 HTTP/1.1\r\n\r\n")
+	if err != errHTTPAuth {
+		t.Fatal("Missing Credentials Accepted")
+	}
+	if resp, err := http.ReadResponse(bufio.NewReader(c), nil); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusProxyAuthRequired || resp.Header.Get("Proxy-Authenticate") == "" {
+		t.Fatalf("Status %d", resp.StatusCode)
+	}
+	c.Close()
+	hc.Close()
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	hc, c, addr, port, err := testHTTPStart(t, auth, "CONNECT www.baidu.com HTTP/1.1\r\nProxy-Authorization: Basic "+credentials+"\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	defer hc.Close()
+	if addr != "www.baidu.com" || port != 443 || hc.User() != "alice" {
+		t.Fatal("Wrong Request")
+	}
+}
+
+func TestHTTPBadRequest(t *testing.T) {
+	hc, c, _, _, err := testHTTPStart(t, nil, "GET /index.html HTTP/1.1\r\nHost: www.baidu.com\r\n\r\n")
+	defer c.Close()
+	defer hc.Close()
+	if err == nil {
+		t.Fatal("Origin-Form Request Accepted")
+	}
+	if resp, err := http.ReadResponse(bufio.NewReader(c), nil); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Status %d", resp.StatusCode)
+	}
+}
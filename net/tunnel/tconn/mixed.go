@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"fmt"
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+)
+
+/* 同一个端口上支持SOCKS5, SOCKS4(a)和HTTP代理 */
+type MixedListener struct {
+	netListener net.Listener
+	auth        Authenticator
+}
+
+/*
+ * Start读取第一个字节判断协议, 之后的操作交给对应协议的连接
+ * 读取的字节放回连接中, 不会丢失
+ */
+type MixedSConn struct {
+	ProxyConn
+	netConn net.Conn
+	auth    Authenticator
+}
+
+func NewMixedListener(address string) (*MixedListener, error) {
+	netListener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &MixedListener{
+		netListener: netListener,
+	}, nil
+}
+
+func (l *MixedListener) Close() {
+	defer l.netListener.Close()
+}
+
+func (l *MixedListener) Addr() net.Addr {
+	return l.netListener.Addr()
+}
+
+/* auth为nil时不需要认证, 在Accept之前设置 */
+func (l *MixedListener) SetAuthenticator(auth Authenticator) error {
+	l.auth = auth
+	return nil
+}
+
+func (l *MixedListener) Accept() (*MixedSConn, error) {
+	netConn, err := l.netListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &MixedSConn{
+		netConn: netConn,
+		auth:    l.auth,
+	}, nil
+}
+
+/* HTTP请求的方法是大写字母 */
+func isHTTPMethodByte(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+func (mc *MixedSConn) Start() (string, uint16, error) {
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(mc.netConn, buf); err != nil {
+		return "", 0, err
+	}
+	conn := NewConn(&bufferedConn{
+		Conn: mc.netConn,
+		buf:  buf,
+	})
+	switch {
+	case buf[0] == socks.Version5 || buf[0] == socks.Version4:
+		mc.ProxyConn = &Socks5SConn{
+			TConn: TConn{
+				conn: conn,
+			},
+			auth: mc.auth,
+		}
+	case isHTTPMethodByte(buf[0]):
+		mc.ProxyConn = &HTTPSConn{
+			TConn: TConn{
+				conn: conn,
+			},
+			auth: mc.auth,
+		}
+	default:
+		return "", 0, fmt.Errorf("Unknown Proxy Protocol %#x", buf[0])
+	}
+	return mc.ProxyConn.Start()
+}
+
+/* Start之后是*Socks5SConn或者*HTTPSConn */
+func (mc *MixedSConn) Conn() ProxyConn {
+	return mc.ProxyConn
+}
+
+func (mc *MixedSConn) User() string {
+	if mc.ProxyConn == nil {
+		return ""
+	}
+	return mc.ProxyConn.User()
+}
+
+func (mc *MixedSConn) Close() {
+	if mc.ProxyConn == nil {
+		mc.netConn.Close()
+		return
+	}
+	mc.ProxyConn.Close()
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"galaxy/protocol/socks"
+	"testing"
+)
+
+func testMixedStart(t *testing.T, request []byte, addr string, port uint16) ProxyConn {
+	l, err := NewMixedListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c := testSendHandshake(t, l.Addr().String(), append(request, "early"...), true)
+	defer c.Close()
+	mc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	a, p, err := mc.Start()
+	if err != nil {
+		t.Fatal(err)
+	} else if a != addr || p != port {
+		t.Fatalf("Wrong Address %s:%d", a, p)
+	}
+	if buf := testReadAll(t, mc, 5); string(buf) != "early" {
+		t.Fatalf("Wrong Early Data %q", buf)
+	}
+	return mc.Conn()
+}
+
+func TestMixedListener(t *testing.T) {
+	var data []byte
+	data = append(data, socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build()...)
+	data = append(data, socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.ATypeDomain, "www.baidu.com", 443).Build()...)
+	if _, ok := testMixedStart(t, data, "www.baidu.com", 443).(*Socks5SConn); !ok {
+		t.Fatal("SOCKS5 Not Detected")
+	}
+
+	data = socks.NewSocks4Request(socks.Version4, socks.CMDConnect, socks.ATypeDomain, "www.baidu.com", 80, "").Build()
+	if _, ok := testMixedStart(t, data, "www.baidu.com", 80).(*Socks5SConn); !ok {
+		t.Fatal("SOCKS4 Not Detected")
+	}
+
+	data = []byte("CONNECT www.baidu.com:443 HTTP/1.1\r\n\r\n")
+	if _, ok := testMixedStart(t, data, "www.baidu.com", 443).(*HTTPSConn); !ok {
+		t.Fatal("HTTP Not Detected")
+	}
+}
+
+func TestMixedUnknownProtocol(t *testing.T) {
+	l, err := NewMixedListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c := testSendHandshake(t, l.Addr().String(), []byte{0x16, 0x03, 0x01}, false)
+	defer c.Close()
+	mc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	if _, _, err := mc.Start(); err == nil {
+		t.Fatal("Unknown Protocol Accepted")
+	}
+}
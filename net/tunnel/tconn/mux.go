@@ -0,0 +1,581 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	/* 每个流的接收窗口, 读取超过一半之后通知对方 */
+	muxWindowSize = 256 * 1024
+	/* 等待Accept的流的数量, 超过时直接关闭新的流 */
+	muxAcceptBacklog = 128
+
+	muxKeepAliveInterval = 30 * time.Second
+	muxKeepAliveTimeout  = 90 * time.Second
+)
+
+var (
+	ErrSessionClosed = errors.New("Session Closed")
+)
+
+/* 承载多路复用的连接, SSLConn或者SSRConn */
+type muxConn interface {
+	IConn
+	Close()
+}
+
+/*
+ * 在一个连接上多路复用多个流, 用于多路复用和反向隧道
+ * 一端用Open打开流, 另一端用Accept接受; 客户端的流ID是奇数, 服务端是偶数
+ * 每个流有自己的发送窗口, 一个流的数据没有被读取时不会阻塞其他流
+ * 定时发送FramePing, 超过timeout没有收到任何数据时关闭
+ */
+type MuxSession struct {
+	conn     muxConn
+	wlock    sync.Mutex
+	lock     sync.Mutex
+	streams  map[uint32]*MuxStream
+	nextID   uint32
+	client   bool
+	accept   chan *MuxStream
+	done     chan struct{}
+	once     sync.Once
+	active   time.Time
+	interval time.Duration
+	timeout  time.Duration
+	pinging  int32
+	ponging  int32
+}
+
+type MuxStream struct {
+	session *MuxSession
+	id      uint32
+	addr    string
+	port    uint16
+
+	lock     sync.Mutex
+	buf      []byte
+	rbuf     [][]byte
+	rsize    int
+	consumed int
+	window   int
+	rnotify  chan struct{}
+	wnotify  chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func NewMuxSession(conn muxConn, client bool) *MuxSession {
+	s := &MuxSession{
+		conn:     conn,
+		streams:  make(map[uint32]*MuxStream),
+		nextID:   2,
+		accept:   make(chan *MuxStream, muxAcceptBacklog),
+		done:     make(chan struct{}),
+		active:   time.Now(),
+		interval: muxKeepAliveInterval,
+		timeout:  muxKeepAliveTimeout,
+	}
+	if client {
+		s.nextID = 1
+		s.client = true
+	}
+	go s.run()
+	go s.keepAlive()
+	return s
+}
+
+/* 发送FramePing的间隔和没有收到数据时关闭的时间 */
+func (s *MuxSession) SetKeepAlive(interval, timeout time.Duration) {
+	s.lock.Lock()
+	s.interval = interval
+	s.timeout = timeout
+	s.lock.Unlock()
+}
+
+func (s *MuxSession) newStream(id uint32, addr string, port uint16) *MuxStream {
+	stream := &MuxStream{
+		session: s,
+		id:      id,
+		addr:    addr,
+		port:    port,
+		window:  muxWindowSize,
+		rnotify: make(chan struct{}, 1),
+		wnotify: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	s.streams[id] = stream
+	return stream
+}
+
+func (s *MuxSession) writeFrame(cmd byte, id uint32, data []byte) error {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	return s.conn.Write(ss.NewFrame(cmd, id, data).Build())
+}
+
+/* 打开一个新的流, addr和port由对方解释 */
+func (s *MuxSession) Open(addr string, port uint16) (*MuxStream, error) {
+	s.lock.Lock()
+	select {
+	case <-s.done:
+		s.lock.Unlock()
+		return nil, ErrSessionClosed
+	default:
+	}
+	stream := s.newStream(s.nextID, addr, port)
+	s.nextID += 2
+	s.lock.Unlock()
+
+	req := ss.NewAddressRequest(socks.GetAddrAType(addr), addr, port)
+	if err := s.writeFrame(ss.FrameOpen, stream.id, req.Build()); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+/* 接受对方打开的流, 会话结束时返回ErrSessionClosed */
+func (s *MuxSession) Accept() (*MuxStream, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.done:
+		return nil, ErrSessionClosed
+	}
+}
+
+/* 连接关闭之后done被关闭 */
+func (s *MuxSession) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *MuxSession) NumStreams() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.streams)
+}
+
+func (s *MuxSession) Close() {
+	s.once.Do(func() {
+		s.lock.Lock()
+		close(s.done)
+		for _, stream := range s.streams {
+			stream.closeRemote()
+		}
+		s.streams = make(map[uint32]*MuxStream)
+		s.lock.Unlock()
+		s.conn.Close()
+	})
+}
+
+func (s *MuxSession) run() {
+	defer s.Close()
+	var buf []byte
+	for {
+		data, err := s.conn.Read()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.active = time.Now()
+		s.lock.Unlock()
+		buf = append(buf, data...)
+		for {
+			frame, err := ss.ParseFrame(buf)
+			if err == socks.ErrIncompleteMessage {
+				break
+			} else if err != nil {
+				return
+			}
+			buf = frame.BUF
+			if err := s.handleFrame(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *MuxSession) keepAlive() {
+	for {
+		s.lock.Lock()
+		interval := s.interval
+		s.lock.Unlock()
+		select {
+		case <-s.done:
+			return
+		case <-time.After(interval):
+		}
+		s.lock.Lock()
+		idle := time.Since(s.active)
+		timeout := s.timeout
+		s.lock.Unlock()
+		if idle > timeout {
+			s.Close()
+			return
+		}
+		/* 写入可能阻塞, 在另外的goroutine中发送, 保证超时检查照常进行; 同时只有一个FramePing */
+		if atomic.CompareAndSwapInt32(&s.pinging, 0, 1) {
+			go func() {
+				s.writeFrame(ss.FramePing, 0, nil)
+				atomic.StoreInt32(&s.pinging, 0)
+			}()
+		}
+	}
+}
+
+func (s *MuxSession) handleFrame(frame *ss.Frame) error {
+	s.lock.Lock()
+	stream := s.streams[frame.ID]
+	s.lock.Unlock()
+	switch frame.CMD {
+	case ss.FrameOpen:
+		req, err := ss.ParseAddressRequest(frame.DATA)
+		if err != nil {
+			return err
+		} else if stream != nil || frame.ID == 0 || (frame.ID%2 == 1) == s.client {
+			/* 对方只能使用自己一侧的ID, 不能和本地打开的流冲突 */
+			return ss.ErrInvalidMessage
+		}
+		s.lock.Lock()
+		stream = s.newStream(frame.ID, req.ADDR, req.PORT)
+		s.lock.Unlock()
+		select {
+		case s.accept <- stream:
+		default:
+			stream.Close()
+		}
+	case ss.FrameData:
+		/* 已经关闭的流的数据被丢弃 */
+		if stream != nil {
+			return stream.push(append([]byte{}, frame.DATA...))
+		}
+	case ss.FrameWindow:
+		if len(frame.DATA) != 4 {
+			return ss.ErrInvalidMessage
+		} else if stream != nil {
+			stream.addWindow(int(binary.BigEndian.Uint32(frame.DATA)))
+		}
+	case ss.FrameClose:
+		if stream != nil {
+			s.removeStream(stream.id)
+			stream.closeRemote()
+		}
+	case ss.FramePing:
+		/* 同时只有一个FramePong, 写入阻塞时多余的FramePing被忽略 */
+		if atomic.CompareAndSwapInt32(&s.ponging, 0, 1) {
+			go func() {
+				s.writeFrame(ss.FramePong, 0, nil)
+				atomic.StoreInt32(&s.ponging, 0)
+			}()
+		}
+	}
+	return nil
+}
+
+func (s *MuxSession) removeStream(id uint32) {
+	s.lock.Lock()
+	delete(s.streams, id)
+	s.lock.Unlock()
+}
+
+/* 流对应的地址 */
+func (stream *MuxStream) Addr() (string, uint16) {
+	return stream.addr, stream.port
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+/* 对方发送的数据超过窗口时是协议错误 */
+func (stream *MuxStream) push(data []byte) error {
+	stream.lock.Lock()
+	if stream.rsize+len(data) > muxWindowSize {
+		stream.lock.Unlock()
+		return ss.ErrInvalidMessage
+	}
+	stream.rbuf = append(stream.rbuf, data)
+	stream.rsize += len(data)
+	stream.lock.Unlock()
+	notify(stream.rnotify)
+	return nil
+}
+
+func (stream *MuxStream) addWindow(n int) {
+	stream.lock.Lock()
+	stream.window += n
+	stream.lock.Unlock()
+	notify(stream.wnotify)
+}
+
+func (stream *MuxStream) isClosed() bool {
+	select {
+	case <-stream.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+/* 对方关闭之后, 先读完已经收到的数据再返回io.EOF */
+func (stream *MuxStream) Read() ([]byte, error) {
+	if len(stream.buf) > 0 {
+		buf := stream.buf
+		stream.buf = nil
+		return buf, nil
+	}
+	for {
+		stream.lock.Lock()
+		if len(stream.rbuf) > 0 {
+			data := stream.rbuf[0]
+			stream.rbuf = stream.rbuf[1:]
+			stream.rsize -= len(data)
+			stream.consumed += len(data)
+			update := 0
+			if stream.consumed >= muxWindowSize/2 {
+				update = stream.consumed
+				stream.consumed = 0
+			}
+			stream.lock.Unlock()
+			if update > 0 && !stream.isClosed() {
+				buf := make([]byte, 4)
+				binary.BigEndian.PutUint32(buf, uint32(update))
+				stream.session.writeFrame(ss.FrameWindow, stream.id, buf)
+			}
+			return data, nil
+		}
+		stream.lock.Unlock()
+		if stream.isClosed() {
+			return nil, io.EOF
+		}
+		select {
+		case <-stream.rnotify:
+		case <-stream.closed:
+		}
+	}
+}
+
+/* 发送窗口用完时等待对方读取 */
+func (stream *MuxStream) Write(data []byte) error {
+	for len(data) > 0 {
+		if stream.isClosed() {
+			return io.ErrClosedPipe
+		}
+		stream.lock.Lock()
+		n := len(data)
+		if n > stream.window {
+			n = stream.window
+		}
+		if n > ss.FrameMaxDataSize {
+			n = ss.FrameMaxDataSize
+		}
+		stream.window -= n
+		stream.lock.Unlock()
+		if n == 0 {
+			select {
+			case <-stream.wnotify:
+			case <-stream.closed:
+			}
+			continue
+		}
+		if err := stream.session.writeFrame(ss.FrameData, stream.id, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+/* 多路复用中服务端连接目标的结果, addr为空时回复0.0.0.0 */
+func (stream *MuxStream) Reply(rep byte, addr string, port uint16) error {
+	if addr == "" {
+		addr = "0.0.0.0"
+	}
+	return stream.Write(ss.NewConnectReply(rep, socks.GetAddrAType(addr), addr, port).Build())
+}
+
+/* 读取服务端的结果, 之后的数据留给Read */
+func (stream *MuxStream) ReadReply() (*ss.ConnectReply, error) {
+	var buf []byte
+	for {
+		rep, err := ss.ParseConnectReply(buf)
+		if err == nil {
+			stream.buf = rep.BUF
+			return rep, nil
+		} else if err != socks.ErrIncompleteMessage {
+			return nil, err
+		}
+		data, err := stream.Read()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+	}
+}
+
+func (stream *MuxStream) closeRemote() {
+	stream.once.Do(func() {
+		close(stream.closed)
+	})
+}
+
+/* 通知对方关闭流 */
+func (stream *MuxStream) Close() {
+	if !stream.isClosed() {
+		stream.session.writeFrame(ss.FrameClose, stream.id, nil)
+	}
+	stream.session.removeStream(stream.id)
+	stream.closeRemote()
+}
+
+/*
+ * 多路复用的连接池, 最多size个连接
+ * 连接数没有达到size并且没有空闲的连接时建立新的连接, 否则使用流最少的连接
+ */
+type MuxPool struct {
+	dial     func() (*SSLConn, error)
+	size     int
+	lock     sync.Mutex
+	sessions []*MuxSession
+	dialing  int
+	cond     *sync.Cond
+	closed   bool
+}
+
+func NewMuxPool(size int, dial func() (*SSLConn, error)) *MuxPool {
+	p := &MuxPool{
+		dial: dial,
+		size: size,
+	}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+func (p *MuxPool) get() (*MuxSession, error) {
+	p.lock.Lock()
+	var best *MuxSession
+	for {
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrSessionClosed
+		}
+		best = nil
+		sessions := make([]*MuxSession, 0, len(p.sessions))
+		for _, s := range p.sessions {
+			select {
+			case <-s.Done():
+				continue
+			default:
+			}
+			sessions = append(sessions, s)
+			if best == nil || s.NumStreams() < best.NumStreams() {
+				best = s
+			}
+		}
+		p.sessions = sessions
+		/* 正在建立的连接也计入size */
+		if best != nil && (len(sessions)+p.dialing >= p.size || best.NumStreams() == 0) {
+			p.lock.Unlock()
+			return best, nil
+		} else if best != nil || p.dialing < p.size {
+			break
+		}
+		/* 没有可用的连接并且已经有size个正在建立, 等待结果 */
+		p.cond.Wait()
+	}
+	p.dialing++
+	p.lock.Unlock()
+
+	/* 建立连接时不持有锁, 不阻塞使用已有连接的请求 */
+	s, err := p.connect()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.dialing--
+	p.cond.Broadcast()
+	if err != nil {
+		if best != nil {
+			return best, nil
+		}
+		return nil, err
+	} else if p.closed {
+		s.Close()
+		return nil, ErrSessionClosed
+	}
+	p.sessions = append(p.sessions, s)
+	return s, nil
+}
+
+func (p *MuxPool) connect() (*MuxSession, error) {
+	ssc, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := ssc.StartMux(); err != nil {
+		ssc.Close()
+		return nil, err
+	}
+	return NewMuxSession(ssc, true), nil
+}
+
+/* 在池中的一个连接上打开流, 服务端连接addr:port */
+func (p *MuxPool) Open(addr string, port uint16) (*MuxStream, error) {
+	s, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	return s.Open(addr, port)
+}
+
+/* 当前活动的连接数 */
+func (p *MuxPool) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := 0
+	for _, s := range p.sessions {
+		select {
+		case <-s.Done():
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+func (p *MuxPool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, s := range p.sessions {
+		s.Close()
+	}
+	p.sessions = nil
+	p.closed = true
+	p.cond.Broadcast()
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+/* 用net.Pipe连接的一对会话 */
+func testMuxPair(t *testing.T) (*MuxSession, *MuxSession) {
+	c1, c2 := net.Pipe()
+	client := NewMuxSession(NewTConn(NewConn(c1)), true)
+	server := NewMuxSession(NewTConn(NewConn(c2)), false)
+	return client, server
+}
+
+func testReadAtLeast(t *testing.T, stream *MuxStream, size int) []byte {
+	var buf []byte
+	for len(buf) < size {
+		data, err := stream.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+func TestMuxSession(t *testing.T) {
+	client, server := testMuxPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	streams := make([]*MuxStream, 3)
+	for i := range streams {
+		stream, err := client.Open("example.com", uint16(80+i))
+		if err != nil {
+			t.Fatal(err)
+		} else if stream.id != uint32(2*i+1) {
+			t.Fatalf("Wrong Stream ID %d", stream.id)
+		}
+		streams[i] = stream
+	}
+	for i := range streams {
+		stream, err := server.Accept()
+		if err != nil {
+			t.Fatal(err)
+		} else if addr, port := stream.Addr(); addr != "example.com" || port != uint16(80+i) {
+			t.Fatalf("Wrong Address %s:%d", addr, port)
+		}
+		data := []byte{byte(i)}
+		if err := stream.Write(data); err != nil {
+			t.Fatal(err)
+		} else if buf := testReadAtLeast(t, streams[i], 1); !bytes.Equal(buf, data) {
+			t.Fatal("Wrong Data")
+		}
+		stream.Close()
+		if _, err := streams[i].Read(); err == nil {
+			t.Fatal("Stream Not Closed")
+		}
+	}
+	if n := client.NumStreams(); n != 0 {
+		t.Fatalf("%d Streams Not Removed", n)
+	}
+
+	/* 会话关闭之后所有的流都被关闭 */
+	stream, err := client.Open("127.0.0.1", 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if _, err := stream.Read(); err == nil {
+		t.Fatal("Stream Not Closed")
+	}
+	if _, err := client.Accept(); err != ErrSessionClosed {
+		t.Fatal("Session Not Closed")
+	}
+}
+
+func TestMuxWindow(t *testing.T) {
+	client, server := testMuxPair(t)
+	defer client.Close()
+	defer server.Close()
+	stream, err := client.Open("127.0.0.1", 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* 超过窗口的数据要等到对方读取之后才能发送 */
+	data := bytes.Repeat([]byte("x"), muxWindowSize+1024)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Write(data)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Window Exceeded")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if buf := testReadAtLeast(t, peer, len(data)); !bytes.Equal(buf, data) {
+		t.Fatal("Wrong Data")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	/* 另一个流不受影响 */
+	other, err := client.Open("127.0.0.1", 81)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Write([]byte("hello"))
+	peer, err = server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	} else if buf := testReadAtLeast(t, peer, 5); string(buf) != "hello" {
+		t.Fatal("Wrong Data")
+	}
+}
+
+func TestMuxKeepAlive(t *testing.T) {
+	client, server := testMuxPair(t)
+	defer server.Close()
+	client.SetKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	server.SetKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-client.Done():
+		t.Fatal("Live Session Closed")
+	default:
+	}
+
+	/* 对方不再回复时关闭 */
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := c2.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	dead := NewMuxSession(NewTConn(NewConn(c1)), true)
+	dead.SetKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	select {
+	case <-dead.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Dead Session Not Closed")
+	}
+
+	/* 对方不读取时FramePing的写入阻塞, 也要按时关闭 */
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	stuck := NewMuxSession(NewTConn(NewConn(c3)), true)
+	stuck.SetKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	select {
+	case <-stuck.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Stuck Session Not Closed")
+	}
+	client.Close()
+}
+
+/* 建立新连接时不阻塞使用已有连接的请求 */
+func TestMuxPoolDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, c)
+		}
+	}()
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	gate := make(chan struct{})
+	dials := 0
+	pool := NewMuxPool(2, func() (*SSLConn, error) {
+		dials++
+		if dials > 1 {
+			<-gate
+		}
+		return SSDial("127.0.0.1", port, "aes-256-gcm", "galaxy")
+	})
+	defer pool.Close()
+
+	if _, err := pool.Open("example.com", 80); err != nil {
+		t.Fatal(err)
+	}
+	/* 第二个请求等待建立连接 */
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.Open("example.com", 80)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	result := make(chan error, 1)
+	go func() {
+		_, err := pool.Open("example.com", 80)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Open Blocked By Dial")
+	}
+	close(gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	} else if pool.Size() != 2 {
+		t.Fatalf("Wrong Pool Size %d", pool.Size())
+	}
+}
+
+/* 对方不能使用本地一侧的流ID */
+func TestMuxStreamID(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	client := NewMuxSession(NewTConn(NewConn(c1)), true)
+	defer client.Close()
+	req := ss.NewAddressRequest(socks.ATypeDomain, "example.com", 80)
+	go c2.Write(ss.NewFrame(ss.FrameOpen, 1, req.Build()).Build())
+	go io.Copy(ioutil.Discard, c2)
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Local Stream ID Accepted")
+	}
+}
+
+/* 对方不读取时大量的FramePing不会产生大量的goroutine */
+func TestMuxPingFlood(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	session := NewMuxSession(NewTConn(NewConn(c1)), false)
+	defer session.Close()
+	n := runtime.NumGoroutine()
+	ping := ss.NewFrame(ss.FramePing, 0, nil).Build()
+	for i := 0; i < 1000; i++ {
+		if _, err := c2.Write(ping); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m := runtime.NumGoroutine(); m > n+10 {
+		t.Fatalf("%d Goroutines For Pongs", m-n)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"errors"
+	"galaxy/protocol/socks"
+	"net"
+)
+
+var (
+	errNotRedirected    = errors.New("Connection Not Redirected")
+	ErrAuthNotSupported = errors.New("Authentication Not Supported")
+)
+
+/* 取得被iptables转发的连接原来的目标地址 */
+type OriginalDstFunc func(c net.Conn) (string, uint16, error)
+
+/*
+ * 透明代理, 接受iptables REDIRECT或者TPROXY转发的TCP连接
+ * 没有握手, 目标地址由originalDst得到
+ */
+type RedirListener struct {
+	netListener net.Listener
+	originalDst OriginalDstFunc
+	tproxy      bool
+}
+
+type RedirSConn struct {
+	TConn
+	originalDst OriginalDstFunc
+	tproxy      bool
+}
+
+/* REDIRECT, 通过SO_ORIGINAL_DST得到目标地址 */
+func NewRedirListener(address string) (*RedirListener, error) {
+	netListener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &RedirListener{
+		netListener: netListener,
+		originalDst: getOriginalDst,
+	}, nil
+}
+
+/* TPROXY, 连接的本地地址就是目标地址, 需要CAP_NET_ADMIN */
+func NewTProxyListener(address string) (*RedirListener, error) {
+	netListener, err := listenTransparent(address)
+	if err != nil {
+		return nil, err
+	}
+	return &RedirListener{
+		netListener: netListener,
+		originalDst: getLocalAddr,
+		tproxy:      true,
+	}, nil
+}
+
+func (l *RedirListener) Close() {
+	defer l.netListener.Close()
+}
+
+func (l *RedirListener) Addr() net.Addr {
+	return l.netListener.Addr()
+}
+
+/* 替换取得目标地址的方法, 在Accept之前设置 */
+func (l *RedirListener) SetOriginalDst(originalDst OriginalDstFunc) {
+	l.originalDst = originalDst
+}
+
+/* 透明代理没有握手, 无法认证, 设置auth时返回错误 */
+func (l *RedirListener) SetAuthenticator(auth Authenticator) error {
+	if auth != nil {
+		return ErrAuthNotSupported
+	}
+	return nil
+}
+
+func (l *RedirListener) Accept() (*RedirSConn, error) {
+	netConn, err := l.netListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &RedirSConn{
+		TConn: TConn{
+			conn: NewConn(netConn),
+		},
+		originalDst: l.originalDst,
+		tproxy:      l.tproxy,
+	}, nil
+}
+
+func getLocalAddr(c net.Conn) (string, uint16, error) {
+	addr := c.LocalAddr().(*net.TCPAddr)
+	return addr.IP.String(), uint16(addr.Port), nil
+}
+
+/* 直接连接到监听端口的连接会造成循环, 拒绝 */
+func (rc *RedirSConn) Start() (string, uint16, error) {
+	addr, port, err := rc.originalDst(rc.conn.Conn)
+	if err != nil {
+		return "", 0, err
+	}
+	if !rc.tproxy {
+		local := rc.conn.LocalAddr().(*net.TCPAddr)
+		if ip := net.ParseIP(addr); ip != nil && ip.Equal(local.IP) && int(port) == local.Port {
+			return "", 0, errNotRedirected
+		}
+	}
+	return addr, port, nil
+}
+
+func (rc *RedirSConn) Command() byte {
+	return socks.CMDConnect
+}
+
+func (rc *RedirSConn) User() string {
+	return ""
+}
+
+/* 没有握手, 失败时直接关闭连接 */
+func (rc *RedirSConn) Reply(rep byte, addr string, port uint16) error {
+	return nil
+}
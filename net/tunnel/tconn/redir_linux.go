@@ -0,0 +1,104 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	soOriginalDst     = 80 /* SO_ORIGINAL_DST */
+	ip6tSoOriginalDst = 80 /* IP6T_SO_ORIGINAL_DST */
+	ipv6Transparent   = 75 /* IPV6_TRANSPARENT */
+)
+
+/* getsockopt(SOL_IP, SO_ORIGINAL_DST), IPv6使用IP6T_SO_ORIGINAL_DST */
+func getOriginalDst(c net.Conn) (string, uint16, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return "", 0, errNotRedirected
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return "", 0, err
+	}
+	ipv6 := c.LocalAddr().(*net.TCPAddr).IP.To4() == nil
+	/*
+	 * 386上getsockopt经由socketcall, 没有SYS_GETSOCKOPT
+	 * 借用大小足够的Getsockopt函数读取sockaddr, 在所有架构上可用
+	 * IPv6Mreq是20字节, 可以放下sockaddr_in; IPv6MTUInfo的开头就是sockaddr_in6
+	 */
+	var addr string
+	var port uint16
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, ip6tSoOriginalDst)
+			if err != nil {
+				serr = err
+				return
+			}
+			sa := info.Addr
+			addr = net.IP(sa.Addr[:]).String()
+			port = binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&sa.Port))[:])
+			return
+		}
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if err != nil {
+			serr = err
+			return
+		}
+		/* sockaddr_in: family(2) port(2) addr(4), 端口是网络字节序 */
+		raw := mreq.Multiaddr
+		addr = net.IPv4(raw[4], raw[5], raw[6], raw[7]).String()
+		port = binary.BigEndian.Uint16(raw[2:4])
+	})
+	if err != nil {
+		return "", 0, err
+	} else if serr != nil {
+		return "", 0, serr
+	}
+	return addr, port, nil
+}
+
+/* 设置IP_TRANSPARENT, 才能接受目标不是本机的连接 */
+func listenTransparent(address string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+				if serr == nil && network == "tcp6" {
+					serr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", address)
+}
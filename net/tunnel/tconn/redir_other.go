@@ -0,0 +1,38 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	errRedirNotSupported = errors.New("Transparent Proxy Not Supported")
+)
+
+func getOriginalDst(c net.Conn) (string, uint16, error) {
+	return "", 0, errRedirNotSupported
+}
+
+func listenTransparent(address string) (net.Listener, error) {
+	return nil, errRedirNotSupported
+}
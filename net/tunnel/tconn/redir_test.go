@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"net"
+	"testing"
+)
+
+func testRedirAccept(t *testing.T, l *RedirListener) (*RedirSConn, net.Conn) {
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc, c
+}
+
+func TestRedirOriginalDst(t *testing.T) {
+	l, err := NewRedirListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	/* 没有经过iptables转发的连接 */
+	rc, c := testRedirAccept(t, l)
+	if _, _, err := rc.Start(); err == nil {
+		t.Fatal("Direct Connection Accepted")
+	}
+	rc.Close()
+	c.Close()
+
+	l.SetOriginalDst(func(c net.Conn) (string, uint16, error) {
+		return "10.0.0.1", 443, nil
+	})
+	rc, c = testRedirAccept(t, l)
+	defer c.Close()
+	defer rc.Close()
+	if addr, port, err := rc.Start(); err != nil {
+		t.Fatal(err)
+	} else if addr != "10.0.0.1" || port != 443 {
+		t.Fatalf("Wrong Address %s:%d", addr, port)
+	}
+}
+
+func TestTProxyListener(t *testing.T) {
+	l, err := NewTProxyListener("127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	rc, c := testRedirAccept(t, l)
+	defer c.Close()
+	defer rc.Close()
+	local := l.Addr().(*net.TCPAddr)
+	if addr, port, err := rc.Start(); err != nil {
+		t.Fatal(err)
+	} else if addr != "127.0.0.1" || int(port) != local.Port {
+		t.Fatalf("Wrong Address %s:%d", addr, port)
+	}
+}
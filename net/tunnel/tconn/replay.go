@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"encoding/binary"
+	"galaxy/cipher"
+	"galaxy/cipher/blake3"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * 防重放过滤器, 记住最近出现过的IV/salt
+ * 使用两个轮换的Bloom过滤器, 当前过滤器满了之后丢弃旧的过滤器,
+ * 按数量轮换而不是按时间, 一个IV至少在之后capacity个连接内会被记住
+ * 容量是预计每小时的连接数时, 大约能记住一个小时
+ */
+
+const (
+	/* 默认每小时的连接数 */
+	defaultReplayCapacity = 100000
+	/* Bloom过滤器的误判率 */
+	replayFalsePositiveRate = 1e-6
+)
+
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+	key    []byte
+}
+
+func newBloomFilter(capacity int, fpRate float64) *bloomFilter {
+	m := math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Ceil(m / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: k,
+		key:    cipher.RandKey(blake3.KeySize),
+	}
+}
+
+/*
+ * 双重哈希: h1 + i * h2
+ * 使用随机密钥的BLAKE3, 攻击者无法构造落在同一位置的IV来提高误判率
+ */
+func (f *bloomFilter) locations(data []byte) (uint64, uint64) {
+	h, _ := blake3.NewKeyed(f.key)
+	h.Write(data)
+	sum := make([]byte, 16)
+	h.Digest(sum)
+	return binary.LittleEndian.Uint64(sum), binary.LittleEndian.Uint64(sum[8:]) | 1
+}
+
+func (f *bloomFilter) Test(data []byte) bool {
+	h1, h2 := f.locations(data)
+	size := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		n := (h1 + uint64(i)*h2) % size
+		if f.bits[n/64]&(1<<(n%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) Add(data []byte) {
+	h1, h2 := f.locations(data)
+	size := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		n := (h1 + uint64(i)*h2) % size
+		f.bits[n/64] |= 1 << (n % 64)
+	}
+}
+
+type replayFilter struct {
+	lock     sync.Mutex
+	capacity int
+	count    int
+	current  *bloomFilter
+	previous *bloomFilter
+	replays  uint64
+}
+
+func newReplayFilter(capacity int) *replayFilter {
+	if capacity <= 0 {
+		capacity = defaultReplayCapacity
+	}
+	return &replayFilter{
+		capacity: capacity,
+		current:  newBloomFilter(capacity, replayFalsePositiveRate),
+		previous: newBloomFilter(capacity, replayFalsePositiveRate),
+	}
+}
+
+func (f *replayFilter) Check(salt []byte) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.current.Test(salt) || f.previous.Test(salt) {
+		atomic.AddUint64(&f.replays, 1)
+		return false
+	}
+	if f.count >= f.capacity {
+		f.previous = f.current
+		f.current = newBloomFilter(f.capacity, replayFalsePositiveRate)
+		f.count = 0
+	}
+	f.current.Add(salt)
+	f.count++
+	return true
+}
+
+func (f *replayFilter) Replays() uint64 {
+	return atomic.LoadUint64(&f.replays)
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"galaxy/cipher"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"testing"
+)
+
+func TestReplayFilter(t *testing.T) {
+	f := newReplayFilter(100)
+	salts := make([][]byte, 150)
+	for i := range salts {
+		salts[i] = cipher.RandKey(16)
+		if !f.Check(salts[i]) {
+			t.Fatal("New Salt Rejected")
+		}
+	}
+	for _, salt := range salts {
+		if f.Check(salt) {
+			t.Fatal("Replayed Salt Accepted")
+		}
+	}
+	if f.Replays() != uint64(len(salts)) {
+		t.Fatal("Wrong Replay Count")
+	}
+
+	/* 两次轮换之后旧的salt被丢弃 */
+	for i := 0; i < 200; i++ {
+		f.Check(cipher.RandKey(16))
+	}
+	if !f.Check(salts[0]) {
+		t.Fatal("Expired Salt Rejected")
+	}
+}
+
+/* 像服务端一样读取第一个请求 */
+func readFirstRequest(info *cipher.CipherInfo, key []byte, filter saltFilter, data []byte) error {
+	reader := newSSReader(info, key, filter)
+	if _, err := readAddressRequest(reader, bytes.NewReader(data), nil); err != nil {
+		return err
+	} else if c, ok := reader.(saltConfirmer); ok {
+		return c.confirmSalt()
+	}
+	return nil
+}
+
+func testReplay(t *testing.T, method string) {
+	info := cipher.GetCipherInfo(method)
+	key := ss.CreateKey("galaxy", info.KeySize)
+	filter := newReplayFilter(defaultReplayCapacity)
+	writer, _ := newSSWriter(info, key)
+	buf := bytes.Buffer{}
+	writer.Write(&buf, ss.NewAddressRequest(socks.ATypeIPv4, "127.0.0.1", 80).Build())
+	data := buf.Bytes()
+
+	if err := readFirstRequest(info, key, filter, data); err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	if err := readFirstRequest(info, key, filter, data); err != errSaltReplayed {
+		t.Fatalf("%s: Replayed Connection Accepted", method)
+	} else if filter.Replays() != 1 {
+		t.Fatalf("%s: Wrong Replay Count", method)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	testReplay(t, "aes-256-cfb")
+	testReplay(t, "chacha20-ietf")
+	testReplay(t, "aes-256-gcm")
+	testReplay(t, "chacha20-ietf-poly1305")
+}
+
+/* 认证失败的数据不记录salt */
+func TestReplayTampered(t *testing.T) {
+	info := cipher.GetCipherInfo("aes-256-gcm")
+	key := ss.CreateKey("galaxy", info.KeySize)
+	filter := newReplayFilter(defaultReplayCapacity)
+	writer, _ := newSSWriter(info, key)
+	buf := bytes.Buffer{}
+	writer.Write(&buf, []byte("hello"))
+	data := buf.Bytes()
+	tampered := append([]byte{}, data...)
+	tampered[info.IvSize] ^= 1
+
+	if _, err := newSSReader(info, key, filter).Read(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("Tampered Chunk Accepted")
+	}
+	if _, err := newSSReader(info, key, filter).Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* 流加密的请求解析失败时不记录IV */
+func TestReplayStreamGarbage(t *testing.T) {
+	info := cipher.GetCipherInfo("aes-256-cfb")
+	key := ss.CreateKey("galaxy", info.KeySize)
+	iv := cipher.RandKey(info.IvSize)
+	filter := newReplayFilter(defaultReplayCapacity)
+
+	write := func(data []byte) []byte {
+		writer, _ := newSSPresetWriter(info, key, iv)
+		buf := bytes.Buffer{}
+		buf.Write(iv)
+		writer.Write(&buf, data)
+		return buf.Bytes()
+	}
+	if err := readFirstRequest(info, key, filter, write([]byte{0x7F, 0, 0, 0, 0})); err == nil {
+		t.Fatal("Garbage Request Accepted")
+	}
+	if err := readFirstRequest(info, key, filter, write(ss.NewAddressRequest(socks.ATypeIPv4, "127.0.0.1", 80).Build())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* 没有IV的加密方式不检查重放, 否则第二个连接会被拒绝 */
+func TestReplayNoIV(t *testing.T) {
+	l, err := NewSSListener("127.0.0.1:0", "none", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	for i := 0; i < 2; i++ {
+		c, err := SSDial("127.0.0.1", port, "none", "galaxy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.Start("127.0.0.1", 80); err != nil {
+			t.Fatal(err)
+		}
+		ssc, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ssc.Close()
+		if _, _, err := ssc.Start(); err != nil {
+			t.Fatalf("Connection %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"galaxy/cipher"
+	"io"
+	"strings"
+)
+
+/*
+ * RSA认证的密钥交换, 客户端只需要服务端的公钥
+ * 客户端: [长度 u16][RSA-OAEP(会话密钥 + IV)][用会话密钥和IV加密的数据...]
+ * 服务端: 和Shadowsocks相同, 使用会话密钥作为主密钥, IV/salt随机生成
+ */
+
+var (
+	errRSAHandshake = errors.New("Invalid RSA Handshake")
+)
+
+func getRSACipherInfo(method string) (*cipher.CipherInfo, error) {
+	info := cipher.GetCipherInfo(strings.ToLower(method))
+	if info == nil {
+		return nil, fmt.Errorf("Method %s Not Found", method)
+	} else if info.SS2022 || info.KeySize == 0 || info.IvSize == 0 {
+		return nil, fmt.Errorf("Method %s Not Supported", method)
+	}
+	return info, nil
+}
+
+/* 用私钥解密会话密钥的服务 */
+func NewRSAListener(address, method string, key *cipher.RSA) (*SSListener, error) {
+	return NewRSATransportListener(TCPTransport, address, method, key)
+}
+
+/* 在transport上监听的RSA服务, 对应RSATransportDial */
+func NewRSATransportListener(transport Transport, address, method string, key *cipher.RSA) (*SSListener, error) {
+	info, err := getRSACipherInfo(method)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := transport.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	return &SSListener{
+		netListener: listener,
+		method:      method,
+		cipherInfo:  info,
+		filter:      newReplayFilter(defaultReplayCapacity),
+		pool:        newSS2022SaltPool(),
+		rsa:         key,
+		handshake:   (*SSRConn).rsaHandshake,
+	}, nil
+}
+
+func (ssc *SSRConn) rsaHandshake() error {
+	l := ssc.listener
+	var sizebuf [2]byte
+	if _, err := io.ReadFull(ssc.conn, sizebuf[:]); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint16(sizebuf[:]))
+	if size != l.rsa.Size() {
+		return errRSAHandshake
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(ssc.conn, buf); err != nil {
+		return err
+	}
+	plain, err := l.rsa.Decrypt(buf)
+	if err != nil {
+		return err
+	}
+	info := l.cipherInfo
+	if len(plain) != info.KeySize+info.IvSize {
+		return errRSAHandshake
+	} else if !l.filter.Check(plain) {
+		return errSaltReplayed
+	}
+	key, iv := plain[:info.KeySize], plain[info.KeySize:]
+	reader, err := newSSPresetReader(info, key, iv)
+	if err != nil {
+		return err
+	}
+	writer, err := newSSWriter(info, key)
+	if err != nil {
+		return err
+	}
+	ssc.cipherInfo = info
+	ssc.reader = reader
+	ssc.writer = writer
+	ssc.key = key
+	return nil
+}
+
+/* 用服务端的公钥加密随机生成的会话密钥 */
+func RSADial(addr string, port uint16, method string, key *cipher.RSA) (*SSLConn, error) {
+	return RSAProxyDial(nil, addr, port, method, key)
+}
+
+func RSAProxyDial(proxy *Socks5Proxy, addr string, port uint16, method string, key *cipher.RSA) (*SSLConn, error) {
+	return rsaDial(proxyDialer(proxy, addr, port), method, key)
+}
+
+func RSATransportDial(transport Transport, addr string, port uint16, method string, key *cipher.RSA) (*SSLConn, error) {
+	return rsaDial(transportDialer(transport, addr, port), method, key)
+}
+
+func rsaDial(dial func() (*Conn, error), method string, key *cipher.RSA) (*SSLConn, error) {
+	info, err := getRSACipherInfo(method)
+	if err != nil {
+		return nil, err
+	}
+	session := cipher.RandKey(info.KeySize)
+	iv := cipher.RandKey(info.IvSize)
+	handshake, err := key.Encrypt(append(append([]byte{}, session...), iv...))
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newSSPresetWriter(info, session, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2, 2+len(handshake))
+	binary.BigEndian.PutUint16(buf, uint16(len(handshake)))
+	if _, err := c.Write(append(buf, handshake...)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &SSLConn{
+		TConn: TConn{
+			conn: c,
+		},
+		cipherInfo: info,
+		reader:     newSSReader(info, session, nil),
+		writer:     writer,
+		key:        session,
+	}, nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"galaxy/cipher"
+	"net"
+	"testing"
+)
+
+func testRSATunnel(t *testing.T, transport Transport, method string, priv, pub *cipher.RSA) {
+	l, err := NewRSATransportListener(transport, "127.0.0.1:0", method, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := uint16(l.netListener.Addr().(*net.TCPAddr).Port)
+
+	plain := bytes.Repeat([]byte("galaxy"), 5000)
+	result := make(chan []byte, 1)
+	go func() {
+		defer close(result)
+		c, err := RSATransportDial(transport, "127.0.0.1", port, method, pub)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Start("example.com", 80)
+		c.Write(append([]byte{}, plain...))
+		var data []byte
+		for len(data) < len(plain) {
+			buf, err := c.Read()
+			if err != nil {
+				return
+			}
+			data = append(data, buf...)
+		}
+		result <- data
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	addr, port, err := c.Start()
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	} else if addr != "example.com" || port != 80 {
+		t.Fatalf("%s: Wrong Address", method)
+	}
+	var data []byte
+	for len(data) < len(plain) {
+		buf, err := c.Read()
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		data = append(data, buf...)
+	}
+	if !bytes.Equal(data, plain) {
+		t.Fatalf("%s: Wrong Request", method)
+	}
+	c.Write(append([]byte{}, data...))
+	if !bytes.Equal(<-result, plain) {
+		t.Fatalf("%s: Wrong Response", method)
+	}
+}
+
+func TestRSATunnel(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := cipher.LoadRSAFromPem(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pub, err := cipher.LoadRSAPublicKeyFromPem(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRSATunnel(t, TCPTransport, "aes-256-cfb", priv, pub)
+	testRSATunnel(t, TCPTransport, "chacha20-ietf", priv, pub)
+	testRSATunnel(t, TCPTransport, "aes-256-gcm", priv, pub)
+	testRSATunnel(t, TCPTransport, "xchacha20-ietf-poly1305", priv, pub)
+	testRSATunnel(t, NewWebSocketTransport("/ws", ""), "aes-256-gcm", priv, pub)
+
+	if _, err := NewRSAListener("127.0.0.1:0", "2022-blake3-aes-256-gcm", priv); err == nil {
+		t.Fatal("SS2022 Method Accepted")
+	}
+}
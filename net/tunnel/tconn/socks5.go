@@ -18,15 +18,29 @@
 package tconn
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"galaxy/protocol/socks"
 	"net"
+	"syscall"
 )
 
+var (
+	/* 目标地址不允许访问 */
+	ErrNotAllowed = errors.New("Not Allowed")
+)
+
+/* 上游代理或者服务端返回的SOCKS5回复码 */
+type ReplyError byte
+
+func (e ReplyError) Error() string {
+	return fmt.Sprintf("Reply %d", byte(e))
+}
+
 type Socks5Listener struct {
 	netListener net.Listener
-	uname       string
-	passwd      string
+	auth        Authenticator
 }
 
 /*
@@ -34,9 +48,13 @@ type Socks5Listener struct {
  */
 type Socks5SConn struct {
 	TConn
-	uname  string
-	passwd string
+	auth Authenticator
+	user string
 
+	reader *bufio.Reader
+	ver    byte
+	cmd    byte
+	userID string
 	reqBuf []byte
 }
 
@@ -54,9 +72,23 @@ func (l *Socks5Listener) Close() {
 	defer l.netListener.Close()
 }
 
+func (l *Socks5Listener) Addr() net.Addr {
+	return l.netListener.Addr()
+}
+
+/* 只有一个用户, 用户名或者密码为空时不需要认证 */
 func (l *Socks5Listener) SetAuth(uname, passwd string) {
-	l.uname = uname
-	l.passwd = passwd
+	if uname == "" || passwd == "" {
+		l.auth = nil
+		return
+	}
+	l.auth = NewMapAuthenticator(map[string]string{uname: passwd})
+}
+
+/* auth为nil时不需要认证, 在Accept之前设置 */
+func (l *Socks5Listener) SetAuthenticator(auth Authenticator) error {
+	l.auth = auth
+	return nil
 }
 
 func (l *Socks5Listener) Accept() (*Socks5SConn, error) {
@@ -68,26 +100,39 @@ func (l *Socks5Listener) Accept() (*Socks5SConn, error) {
 		TConn: TConn{
 			conn: NewConn(netConn),
 		},
-		uname:  l.uname,
-		passwd: l.passwd,
+		auth: l.auth,
 	}, nil
 }
 
-func (sc *Socks5SConn) doMethodSelection() (byte, error) {
-	buf := make([]byte, 256)
-	conn := sc.conn
-	n, err := conn.Read(buf)
+func (sc *Socks5SConn) doSocks4Request() (string, uint16, error) {
+	req, err := socks.ReadSocks4Request(sc.reader)
 	if err != nil {
-		return 0, err
+		return "", 0, err
 	}
-	req, err := socks.ParseMethodSelectionRequest(buf[:n])
+	if req.CMD != socks.CMDConnect || sc.auth != nil {
+		/* SOCKS4无法验证密码，设置了认证时一律拒绝 */
+		rep := socks.NewSocks4Reply(socks.Socks4ReplyRejected, "", 0)
+		sc.conn.Write(rep.Build())
+		if req.CMD != socks.CMDConnect {
+			return "", 0, fmt.Errorf("Invalid Command %d", req.CMD)
+		}
+		return "", 0, fmt.Errorf("Authentication Required")
+	}
+	sc.cmd = req.CMD
+	sc.userID = req.USERID
+	return req.ADDR, req.PORT, nil
+}
+
+func (sc *Socks5SConn) doMethodSelection() (byte, error) {
+	conn := sc.conn
+	req, err := socks.ReadMethodSelectionRequest(sc.reader)
 	if err != nil {
 		return 0, err
 	} else if req.VER != socks.Version5 {
 		return 0, fmt.Errorf("Invalid Version %d", req.VER)
 	}
 	method := socks.MethodNoAuthRequired
-	if sc.uname != "" && sc.passwd != "" {
+	if sc.auth != nil {
 		method = socks.MethodUsernamePassword
 	}
 	rep := socks.NewMethodSelectionReply(socks.Version5, socks.MethodNoAcceptable)
@@ -106,51 +151,85 @@ func (sc *Socks5SConn) doMethodSelection() (byte, error) {
 }
 
 func (sc *Socks5SConn) doUsernamePassword() error {
-	buf := make([]byte, 1024)
 	conn := sc.conn
-	n, err := conn.Read(buf)
+	req, err := socks.ReadUsernamePasswordRequest(sc.reader)
 	if err != nil {
 		return err
 	}
-	req, err := socks.ParseUsernamePasswordRequest(buf[:n])
-	if err != nil {
-		return err
-	}
-	passed := req.UNAME == sc.uname && req.PASSWD == sc.passwd
+	passed := sc.auth.Authenticate(req.UNAME, req.PASSWD)
 	status := socks.UsernamePasswordStatusSuccess
 	if !passed {
 		status = socks.UsernamePasswordStatusFailure
 	}
-	rep := socks.NewUsernamePasswordReply(socks.Version5, status)
+	rep := socks.NewUsernamePasswordReply(socks.UsernamePasswordVersion, status)
 	if _, err := conn.Write(rep.Build()); err != nil {
 		return err
 	} else if !passed {
 		return fmt.Errorf("Invalid Username/Password")
 	}
+	sc.user = req.UNAME
 	return nil
 }
 
 func (sc *Socks5SConn) doCMDRequest() (string, uint16, error) {
-	buf := make([]byte, 2048)
-	conn := sc.conn
-	n, err := conn.Read(buf)
-	if err != nil {
+	req, err := socks.ReadSocks5Request(sc.reader)
+	if err == socks.ErrInvalidAddrType {
+		sc.Reply(socks.ReplyAddressTypeNotSupported, "", 0)
 		return "", 0, err
-	}
-	req, err := socks.ParseSocks5Request(buf[:n])
-	if err != nil {
+	} else if err != nil {
 		return "", 0, err
 	}
-	if req.CMD == socks.CMDConnect {
-		sc.reqBuf = req.BUF
-	} else {
+	if req.CMD != socks.CMDConnect && req.CMD != socks.CMDUDPAssociate && req.CMD != socks.CMDBind {
+		sc.Reply(socks.ReplyCommandNotSupported, "", 0)
 		return "", 0, fmt.Errorf("Invalid Command %d", req.CMD)
 	}
+	sc.cmd = req.CMD
 	return req.ADDR, req.PORT, nil
 }
 
-/* 执行SOCKS5协议的初始化过程 */
+/* Start之后得到客户端请求的命令 */
+func (sc *Socks5SConn) Command() byte {
+	return sc.cmd
+}
+
+/* 认证通过的用户名, 不需要认证时为空 */
+func (sc *Socks5SConn) User() string {
+	return sc.user
+}
+
+/* SOCKS4请求中的USERID, 没有经过认证 */
+func (sc *Socks5SConn) UserID() string {
+	return sc.userID
+}
+
+/*
+ * 执行SOCKS协议的初始化过程，支持SOCKS5和SOCKS4(a)
+ * 握手消息可能被拆分或者合并, 从reader中按长度读取
+ * 握手之后已经读到的数据留给Read
+ */
 func (sc *Socks5SConn) Start() (string, uint16, error) {
+	sc.reader = bufio.NewReader(sc.conn)
+	addr, port, err := sc.doHandshake()
+	if err != nil {
+		return "", 0, err
+	}
+	if n := sc.reader.Buffered(); n > 0 {
+		buf, _ := sc.reader.Peek(n)
+		sc.reqBuf = append([]byte{}, buf...)
+	}
+	sc.reader = nil
+	return addr, port, nil
+}
+
+func (sc *Socks5SConn) doHandshake() (string, uint16, error) {
+	ver, err := sc.reader.Peek(1)
+	if err != nil {
+		return "", 0, err
+	}
+	sc.ver = ver[0]
+	if sc.ver == socks.Version4 {
+		return sc.doSocks4Request()
+	}
 	if method, err := sc.doMethodSelection(); err != nil {
 		return "", 0, err
 	} else if method == socks.MethodUsernamePassword {
@@ -162,25 +241,64 @@ func (sc *Socks5SConn) Start() (string, uint16, error) {
 }
 
 func (sc *Socks5SConn) Notify(addr string, port uint16, success bool) error {
-	atype := socks.ATypeDomain
-	if ip := net.ParseIP(addr); ip != nil {
-		if len(ip) == 4 {
-			atype = socks.ATypeIPv4
-		} else {
-			atype = socks.ATypeIPv6
-		}
-	}
-	status := socks.ReplySuccess
+	rep := socks.ReplySuccess
 	if !success {
-		status = socks.ReplyGeneralFailure
+		rep = socks.ReplyGeneralFailure
+	}
+	return sc.Reply(rep, addr, port)
+}
+
+/* rep是SOCKS5的回复码, SOCKS4只区分成功和失败; addr为空时回复0.0.0.0 */
+func (sc *Socks5SConn) Reply(rep byte, addr string, port uint16) error {
+	if addr == "" {
+		addr = "0.0.0.0"
+	}
+	if sc.ver == socks.Version4 {
+		status := socks.Socks4ReplyGranted
+		if rep != socks.ReplySuccess {
+			status = socks.Socks4ReplyRejected
+		}
+		reply := socks.NewSocks4Reply(status, addr, port)
+		if _, err := sc.conn.Write(reply.Build()); err != nil {
+			return err
+		}
+		return nil
 	}
-	rep := socks.NewSocks5Reply(socks.Version5, status, atype, addr, port)
-	if _, err := sc.conn.Write(rep.Build()); err != nil {
+	atype := socks.GetAddrAType(addr)
+	reply := socks.NewSocks5Reply(socks.Version5, rep, atype, addr, port)
+	if _, err := sc.conn.Write(reply.Build()); err != nil {
 		return err
 	}
 	return nil
 }
 
+/* 把连接目标时的错误转换为SOCKS5的回复码 */
+func ReplyCode(err error) byte {
+	var replyErr ReplyError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return socks.ReplySuccess
+	case errors.As(err, &replyErr):
+		return byte(replyErr)
+	case errors.Is(err, ErrNotAllowed), errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return socks.ReplyConnectionNowAllowed
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks.ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks.ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return socks.ReplyHostUnreachable
+	case errors.Is(err, socks.ErrInvalidAddrType):
+		return socks.ReplyAddressTypeNotSupported
+	case errors.As(err, &netErr) && netErr.Timeout():
+		/* 连接超时 */
+		return socks.ReplyTTLExpired
+	}
+	return socks.ReplyGeneralFailure
+}
+
 func (sc *Socks5SConn) Read() ([]byte, error) {
 	if len(sc.reqBuf) != 0 {
 		buf := sc.reqBuf
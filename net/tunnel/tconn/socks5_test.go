@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"galaxy/cipher"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"io"
+	"net"
+	"testing"
+)
+
+/* oneByte为true时每次只发送一个字节, 否则一次发送全部数据 */
+func testSendHandshake(t *testing.T, address string, data []byte, oneByte bool) net.Conn {
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if !oneByte {
+			c.Write(data)
+			return
+		}
+		for i := range data {
+			if _, err := c.Write(data[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func testReadAll(t *testing.T, conn IConn, size int) []byte {
+	var buf []byte
+	for len(buf) < size {
+		data, err := conn.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+func testSocks5Handshake(t *testing.T, data []byte, uname, passwd, addr string, port uint16) {
+	for _, oneByte := range []bool{true, false} {
+		l, err := NewSocks5Listener("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.SetAuth(uname, passwd)
+		c := testSendHandshake(t, l.Addr().String(), append(append([]byte{}, data...), "early"...), oneByte)
+		sc, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, p, err := sc.Start()
+		if err != nil {
+			t.Fatal(err)
+		} else if a != addr || p != port {
+			t.Fatalf("Wrong Address %s:%d", a, p)
+		}
+		if buf := testReadAll(t, sc, 5); string(buf) != "early" {
+			t.Fatalf("Wrong Early Data %q", buf)
+		}
+		sc.Close()
+		c.Close()
+		l.Close()
+	}
+}
+
+func TestSocks5Handshake(t *testing.T) {
+	var data []byte
+	data = append(data, socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build()...)
+	data = append(data, socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.ATypeDomain, "www.baidu.com", 443).Build()...)
+	testSocks5Handshake(t, data, "", "", "www.baidu.com", 443)
+
+	data = nil
+	data = append(data, socks.NewMethodSelectionRequest(socks.Version5, socks.MethodUsernamePassword).Build()...)
+	data = append(data, socks.NewUsernamePasswordRequest(socks.UsernamePasswordVersion, "galaxy", "secret").Build()...)
+	data = append(data, socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.ATypeIPv6, "::1", 80).Build()...)
+	testSocks5Handshake(t, data, "galaxy", "secret", "::1", 80)
+
+	data = socks.NewSocks4Request(socks.Version4, socks.CMDConnect, socks.ATypeDomain, "www.baidu.com", 80, "jim").Build()
+	testSocks5Handshake(t, data, "", "", "www.baidu.com", 80)
+}
+
+/* 地址和数据被拆分成单个字节发送 */
+func testSSHandshake(t *testing.T, method string) {
+	l, err := NewSSListener("127.0.0.1:0", method, "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	info := cipher.GetCipherInfo(method)
+	key, _ := createSSKey(info, "galaxy")
+	writer, err := newSSWriter(info, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	req := ss.NewAddressRequest(socks.ATypeDomain, "www.baidu.com", 443)
+	writer.Write(&buf, append(req.Build(), "early"...))
+
+	c := testSendHandshake(t, l.Addr().String(), buf.Bytes(), true)
+	defer c.Close()
+	ssc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ssc.Close()
+	addr, port, err := ssc.Start()
+	if err != nil {
+		t.Fatal(err)
+	} else if addr != "www.baidu.com" || port != 443 {
+		t.Fatalf("%s: Wrong Address %s:%d", method, addr, port)
+	}
+	if data := testReadAll(t, ssc, 5); string(data) != "early" {
+		t.Fatalf("%s: Wrong Early Data %q", method, data)
+	}
+}
+
+func TestSSHandshake(t *testing.T) {
+	testSSHandshake(t, "aes-128-cfb")
+	testSSHandshake(t, "chacha20-ietf")
+	testSSHandshake(t, "aes-256-gcm")
+}
+
+func TestReplyCode(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	_, refused := net.Dial("tcp", address)
+
+	cases := []struct {
+		err error
+		rep byte
+	}{
+		{nil, socks.ReplySuccess},
+		{refused, socks.ReplyConnectionRefused},
+		{&net.DNSError{Err: "no such host", Name: "nonexistent.invalid"}, socks.ReplyHostUnreachable},
+		{ErrNotAllowed, socks.ReplyConnectionNowAllowed},
+		{ReplyError(socks.ReplyNetworkUnreachable), socks.ReplyNetworkUnreachable},
+		{socks.ErrInvalidAddrType, socks.ReplyAddressTypeNotSupported},
+		{io.EOF, socks.ReplyGeneralFailure},
+	}
+	for _, c := range cases {
+		if rep := ReplyCode(c.err); rep != c.rep {
+			t.Fatalf("%v: Reply %d, Expect %d", c.err, rep, c.rep)
+		}
+	}
+}
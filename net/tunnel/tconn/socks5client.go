@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"fmt"
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"strconv"
+)
+
+/* 上游SOCKS5代理, uname为空时不认证 */
+type Socks5Proxy struct {
+	address string
+	uname   string
+	passwd  string
+}
+
+/*
+ * Socks5 Client Conn
+ */
+type Socks5CConn struct {
+	TConn
+	boundAddr string
+	boundPort uint16
+}
+
+func NewSocks5Proxy(address, uname, passwd string) *Socks5Proxy {
+	return &Socks5Proxy{
+		address: address,
+		uname:   uname,
+		passwd:  passwd,
+	}
+}
+
+func (p *Socks5Proxy) Address() string {
+	return p.address
+}
+
+/* 连接代理并请求CONNECT到addr:port, 返回的连接之后直接传输数据 */
+func (p *Socks5Proxy) Dial(addr string, port uint16) (*Conn, error) {
+	c, _, _, err := p.dial(addr, port)
+	return c, err
+}
+
+/* 同时返回代理服务器用于连接目标的地址 */
+func (p *Socks5Proxy) dial(addr string, port uint16) (*Conn, string, uint16, error) {
+	c, err := Dial("tcp", p.address)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	baddr, bport, err := p.handshake(c, addr, port)
+	if err != nil {
+		c.Close()
+		return nil, "", 0, err
+	}
+	return c, baddr, bport, nil
+}
+
+func (p *Socks5Proxy) handshake(c *Conn, addr string, port uint16) (string, uint16, error) {
+	method := socks.MethodNoAuthRequired
+	if p.uname != "" {
+		method = socks.MethodUsernamePassword
+	}
+	if err := p.doMethodSelection(c, method); err != nil {
+		return "", 0, err
+	} else if method == socks.MethodUsernamePassword {
+		if err := p.doUsernamePassword(c); err != nil {
+			return "", 0, err
+		}
+	}
+	return p.doConnect(c, addr, port)
+}
+
+func (p *Socks5Proxy) doMethodSelection(c *Conn, method byte) error {
+	req := socks.NewMethodSelectionRequest(socks.Version5, method)
+	if _, err := c.Write(req.Build()); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	rep, err := socks.ParseMethodSelectionReply(buf)
+	if err != nil {
+		return err
+	} else if rep.VER != socks.Version5 {
+		return fmt.Errorf("Invalid Version %d", rep.VER)
+	} else if rep.METHOD != method {
+		return fmt.Errorf("No Acceptable Method")
+	}
+	return nil
+}
+
+/* RFC 1929 */
+func (p *Socks5Proxy) doUsernamePassword(c *Conn) error {
+	if len(p.uname) > 255 || len(p.passwd) == 0 || len(p.passwd) > 255 {
+		return fmt.Errorf("Invalid Username/Password")
+	}
+	req := socks.NewUsernamePasswordRequest(socks.UsernamePasswordVersion, p.uname, p.passwd)
+	if _, err := c.Write(req.Build()); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	rep, err := socks.ParseUsernamePasswordReply(buf)
+	if err != nil {
+		return err
+	} else if rep.STATUS != socks.UsernamePasswordStatusSuccess {
+		return fmt.Errorf("Invalid Username/Password")
+	}
+	return nil
+}
+
+/* 只读取回复本身, 之后的数据留给调用者 */
+func (p *Socks5Proxy) doConnect(c *Conn, addr string, port uint16) (string, uint16, error) {
+	req := socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.GetAddrAType(addr), addr, port)
+	if _, err := c.Write(req.Build()); err != nil {
+		return "", 0, err
+	}
+	rep, err := socks.ReadSocks5Reply(c)
+	if err != nil {
+		return "", 0, err
+	} else if rep.VER != socks.Version5 {
+		return "", 0, fmt.Errorf("Invalid Version %d", rep.VER)
+	} else if rep.REP != socks.ReplySuccess {
+		return "", 0, ReplyError(rep.REP)
+	}
+	return rep.ADDR, rep.PORT, nil
+}
+
+/* 通过上游SOCKS5代理连接addr:port */
+func Socks5Dial(proxy *Socks5Proxy, addr string, port uint16) (*Socks5CConn, error) {
+	c, baddr, bport, err := proxy.dial(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	return &Socks5CConn{
+		TConn: TConn{
+			conn: c,
+		},
+		boundAddr: baddr,
+		boundPort: bport,
+	}, nil
+}
+
+/* 代理服务器用于连接目标的地址 */
+func (cc *Socks5CConn) BoundAddr() (string, uint16) {
+	return cc.boundAddr, cc.boundPort
+}
+
+/* proxy为nil时直接连接 */
+func dialTCP(proxy *Socks5Proxy, addr string, port uint16) (*Conn, error) {
+	if proxy == nil {
+		return Dial("tcp", net.JoinHostPort(addr, strconv.Itoa(int(port))))
+	}
+	return proxy.Dial(addr, port)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+/* 用Socks5Listener作为上游代理, 连接后直接转发 */
+func startTestSocks5Proxy(t *testing.T, uname, passwd string) (*Socks5Listener, chan string) {
+	l, err := NewSocks5Listener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetAuth(uname, passwd)
+	targets := make(chan string, 1)
+	go func() {
+		for {
+			sc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer sc.Close()
+				addr, port, err := sc.Start()
+				if err != nil {
+					return
+				}
+				targets <- addr
+				c, err := Dial("tcp", fmt.Sprintf("%s:%d", addr, port))
+				sc.Notify("127.0.0.1", 1080, err == nil)
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				go io.Copy(c, sc.conn)
+				io.Copy(sc.conn, c)
+			}()
+		}
+	}()
+	return l, targets
+}
+
+func startTestEcho(t *testing.T) (net.Listener, uint16) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l, uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func testSocks5Dial(t *testing.T, uname, passwd string) {
+	echo, port := startTestEcho(t)
+	defer echo.Close()
+	l, targets := startTestSocks5Proxy(t, uname, passwd)
+	defer l.Close()
+
+	proxy := NewSocks5Proxy(l.Addr().String(), uname, passwd)
+	cc, err := Socks5Dial(proxy, "localhost", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if addr := <-targets; addr != "localhost" {
+		t.Fatalf("Wrong Target %s", addr)
+	}
+	if addr, port := cc.BoundAddr(); addr != "127.0.0.1" || port != 1080 {
+		t.Fatal("Wrong Bound Address")
+	}
+	if err := cc.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(cc.conn, buf); err != nil || !bytes.Equal(buf, []byte("hello")) {
+		t.Fatal("Wrong Echo Data")
+	}
+}
+
+func TestSocks5Dial(t *testing.T) {
+	testSocks5Dial(t, "", "")
+	testSocks5Dial(t, "galaxy", "secret")
+}
+
+func TestSocks5DialAuthFailed(t *testing.T) {
+	l, _ := startTestSocks5Proxy(t, "galaxy", "secret")
+	defer l.Close()
+	if _, err := Socks5Dial(NewSocks5Proxy(l.Addr().String(), "galaxy", "wrong"), "127.0.0.1", 80); err == nil {
+		t.Fatal("Wrong Password Accepted")
+	}
+	if _, err := Socks5Dial(NewSocks5Proxy(l.Addr().String(), "", ""), "127.0.0.1", 80); err == nil {
+		t.Fatal("No Auth Accepted")
+	}
+}
+
+func TestSSProxyDial(t *testing.T) {
+	sl, err := NewSSListener("127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sl.Close()
+	go func() {
+		ssc, err := sl.Accept()
+		if err != nil {
+			return
+		}
+		defer ssc.Close()
+		addr, port, err := ssc.Start()
+		if err != nil {
+			return
+		}
+		ssc.Write([]byte(fmt.Sprintf("%s:%d", addr, port)))
+	}()
+	l, targets := startTestSocks5Proxy(t, "", "")
+	defer l.Close()
+
+	proxy := NewSocks5Proxy(l.Addr().String(), "", "")
+	port := uint16(sl.Addr().(*net.TCPAddr).Port)
+	ssc, err := SSProxyDial(proxy, "127.0.0.1", port, "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ssc.Close()
+	if addr := <-targets; addr != "127.0.0.1" {
+		t.Fatalf("Wrong Target %s", addr)
+	}
+	if err := ssc.Start("example.com", 443); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ssc.Read(); err != nil || string(data) != "example.com:443" {
+		t.Fatal("Wrong Address Through Proxy")
+	}
+}
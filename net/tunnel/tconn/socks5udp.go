@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"galaxy/protocol/socks"
+	"net"
+	"sync"
+)
+
+/*
+ * SOCKS5 UDP ASSOCIATE的中继端口
+ * 只接受来自TCP控制连接客户端IP的数据包, 不支持分片
+ */
+type Socks5UDPConn struct {
+	conn     *net.UDPConn
+	clientIP net.IP
+	lock     sync.Mutex
+	client   *net.UDPAddr
+}
+
+/* 在TCP连接的本地地址上监听UDP */
+func (sc *Socks5SConn) ListenUDP() (*Socks5UDPConn, error) {
+	local := sc.conn.LocalAddr().(*net.TCPAddr)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		return nil, err
+	}
+	return &Socks5UDPConn{
+		conn:     conn,
+		clientIP: sc.conn.RemoteAddr().(*net.TCPAddr).IP,
+	}, nil
+}
+
+/* 中继端口的地址, 在UDP ASSOCIATE的回复中返回给客户端 */
+func (c *Socks5UDPConn) Addr() (string, uint16) {
+	addr := c.conn.LocalAddr().(*net.UDPAddr)
+	return addr.IP.String(), uint16(addr.Port)
+}
+
+func (c *Socks5UDPConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Socks5UDPConn) ReadFrom() (*socks.Socks5UDPMessage, error) {
+	buf := make([]byte, ssMaxPacketSize)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, err
+		} else if !from.IP.Equal(c.clientIP) {
+			continue
+		}
+		msg, err := socks.ParseSocks5UDPMessage(buf[:n])
+		if err != nil || msg.FRAG != 0 {
+			continue
+		}
+		c.lock.Lock()
+		c.client = from
+		c.lock.Unlock()
+		return msg, nil
+	}
+}
+
+/* 发送给最近一个数据包的来源 */
+func (c *Socks5UDPConn) WriteTo(msg *socks.Socks5UDPMessage) error {
+	c.lock.Lock()
+	client := c.client
+	c.lock.Unlock()
+	if client == nil {
+		return nil
+	}
+	_, err := c.conn.WriteToUDP(msg.Build(), client)
+	return err
+}
@@ -22,23 +22,50 @@ import (
 	"galaxy/cipher"
 	"galaxy/protocol/socks"
 	"galaxy/protocol/ss"
+	"io"
 	"net"
 	"strings"
+	"sync"
 )
 
+/*
+ * 多用户模式下cipherInfo为nil, 每个连接的加密方式由用户决定
+ * RSA和X25519模式下每个连接的密钥通过握手得到
+ * handshake不为nil时, 连接的读写器在Start中由handshake创建
+ */
 type SSListener struct {
 	netListener net.Listener
 	method      string
 	password    string
 	cipherInfo  *cipher.CipherInfo
+	key         []byte
+	filter      saltFilter
+	pool        saltFilter
+	userLock    sync.RWMutex
+	users       map[string]*ssUser
+	userList    []*ssUser
+	rsa         *cipher.RSA
+	handshake   func(*SSRConn) error
 }
 
 func NewSSListener(address, method, password string) (*SSListener, error) {
+	return NewSSTransportListener(TCPTransport, address, method, password)
+}
+
+/* 在transport上监听, 例如WebSocket */
+func NewSSTransportListener(transport Transport, address, method, password string) (*SSListener, error) {
+	if IsX25519Method(method) {
+		return newX25519Listener(transport, address, method, password)
+	}
 	cipherInfo := cipher.GetCipherInfo(strings.ToLower(method))
 	if cipherInfo == nil {
 		return nil, fmt.Errorf("Method %s Not Found", method)
 	}
-	listener, err := net.Listen("tcp", address)
+	key, err := createSSKey(cipherInfo, password)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := transport.Listen(address)
 	if err != nil {
 		return nil, err
 	}
@@ -47,21 +74,92 @@ func NewSSListener(address, method, password string) (*SSListener, error) {
 		method:      method,
 		password:    password,
 		cipherInfo:  cipherInfo,
+		key:         key,
+		filter:      newReplayFilter(defaultReplayCapacity),
+		pool:        newSS2022SaltPool(),
 	}, nil
 }
 
+/* 多用户服务, users是用户名到加密方式和密码的映射 */
+func NewSSMultiListener(address string, users map[string]SSUser) (*SSListener, error) {
+	return NewSSMultiTransportListener(TCPTransport, address, users)
+}
+
+/* 在transport上监听的多用户服务 */
+func NewSSMultiTransportListener(transport Transport, address string, users map[string]SSUser) (*SSListener, error) {
+	table := make(map[string]*ssUser)
+	for id, user := range users {
+		u, err := newSSUser(id, user)
+		if err != nil {
+			return nil, err
+		}
+		table[id] = u
+	}
+	listener, err := transport.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	l := &SSListener{
+		netListener: listener,
+		filter:      newReplayFilter(defaultReplayCapacity),
+		pool:        newSS2022SaltPool(),
+		users:       table,
+		handshake:   (*SSRConn).identify,
+	}
+	l.sortUsers()
+	return l, nil
+}
+
 func (l *SSListener) Close() {
 	defer l.netListener.Close()
 }
 
+func (l *SSListener) Addr() net.Addr {
+	return l.netListener.Addr()
+}
+
+/*
+ * 设置防重放过滤器的大小, connsPerHour是预计每小时的连接数
+ * SS2022的salt按时间过期, 不受影响
+ * 没有加锁, 需要在Accept之前(隧道Run之前)调用
+ */
+func (l *SSListener) SetReplayCapacity(connsPerHour int) {
+	l.filter = newReplayFilter(connsPerHour)
+}
+
+/* 发现的重放连接数 */
+func (l *SSListener) Replays() uint64 {
+	return l.filter.Replays() + l.pool.Replays()
+}
+
+func (l *SSListener) saltFilter(info *cipher.CipherInfo) saltFilter {
+	if info.SS2022 {
+		return l.pool
+	}
+	return l.filter
+}
+
 func (l *SSListener) Accept() (*SSRConn, error) {
 	c, err := l.netListener.Accept()
 	if err != nil {
 		return nil, err
 	}
-	key := ss.CreateKey(l.password, l.cipherInfo.KeySize)
-	iv := cipher.RandKey(l.cipherInfo.IvSize)
-	encrypter := l.cipherInfo.EncrypterFunc(key, iv)
+	if l.handshake != nil {
+		/* 在Start中识别用户或者交换密钥 */
+		return &SSRConn{
+			TConn: TConn{
+				conn: &Conn{
+					Conn: c,
+				},
+			},
+			listener: l,
+		}, nil
+	}
+	reader, writer, err := newSSReadWriter(l.cipherInfo, l.key, true, l.saltFilter(l.cipherInfo))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
 	return &SSRConn{
 		TConn: TConn{
 			conn: &Conn{
@@ -69,11 +167,9 @@ func (l *SSListener) Accept() (*SSRConn, error) {
 			},
 		},
 		cipherInfo: l.cipherInfo,
-		encrypter:  encrypter,
-		decrypter:  nil,
-		key:        key,
-		iv:         iv,
-		ivSent:     false,
+		reader:     reader,
+		writer:     writer,
+		key:        l.key,
 		buf:        nil,
 	}, nil
 }
@@ -81,56 +177,98 @@ func (l *SSListener) Accept() (*SSRConn, error) {
 type SSRConn struct {
 	TConn
 	cipherInfo *cipher.CipherInfo
-	encrypter  cipher.Encrypter
-	decrypter  cipher.Decrypter
+	reader     ssReader
+	writer     ssWriter
 	key        []byte
-	iv         []byte
 	buf        []byte
-	ivSent     bool
+	cmd        byte
+	reply      bool
+	listener   *SSListener
+	user       string
 }
 
-func (ssc *SSRConn) Start() (string, uint16, error) {
-	if ssc.decrypter != nil {
-		return "", 0, nil
+/* 多用户模式下连接所属的用户 */
+func (ssc *SSRConn) User() string {
+	return ssc.user
+}
+
+func (ssc *SSRConn) identify() error {
+	user, buf, err := ssc.listener.identify(ssc.conn.Conn)
+	if err != nil {
+		return err
 	}
-	ivbuf := make([]byte, ssc.cipherInfo.IvSize)
-	if _, err := ssc.conn.Read(ivbuf); err != nil {
-		return "", 0, err
+	reader, writer, err := newSSReadWriter(user.info, user.key, true, ssc.listener.saltFilter(user.info))
+	if err != nil {
+		return err
+	}
+	ssc.conn = &Conn{
+		Conn: &bufferedConn{
+			Conn: ssc.conn.Conn,
+			buf:  buf,
+		},
 	}
-	ssc.decrypter = ssc.cipherInfo.DecrypterFunc(ssc.key, ivbuf)
+	ssc.cipherInfo = user.info
+	ssc.reader = reader
+	ssc.writer = writer
+	ssc.key = user.key
+	ssc.user = user.id
+	return nil
+}
 
-	data, err := ssc.Read()
-	if err != nil {
-		return "", 0, err
+func (ssc *SSRConn) Start() (string, uint16, error) {
+	if ssc.reader == nil {
+		if err := ssc.listener.handshake(ssc); err != nil {
+			return "", 0, err
+		}
 	}
-	req, err := ss.ParseAddressRequest(data)
+	req, err := readAddressRequest(ssc.reader, ssc.conn, nil)
 	if err != nil {
 		return "", 0, err
 	}
+	if c, ok := ssc.reader.(saltConfirmer); ok {
+		if err := c.confirmSalt(); err != nil {
+			return "", 0, err
+		}
+	}
 	ssc.buf = req.BUF
+	ssc.cmd = req.CMD
+	ssc.reply = req.REPLY
 	return req.ADDR, req.PORT, nil
 }
 
+/* Start之后得到客户端请求的命令, socks.CMDConnect, socks.CMDBind, ss.CMDReverse或者ss.CMDMux */
+func (ssc *SSRConn) Command() byte {
+	return ssc.cmd
+}
+
+/* BIND命令中, 把监听的地址和连接进来的地址发送给客户端 */
+func (ssc *SSRConn) WriteAddress(addr string, port uint16) error {
+	req := ss.NewAddressRequest(socks.GetAddrAType(addr), addr, port)
+	return ssc.Write(req.Build())
+}
+
+/* 客户端请求了连接结果时, 返回回复码和连接目标使用的地址 */
+func (ssc *SSRConn) Reply(rep byte, addr string, port uint16) error {
+	if !ssc.reply {
+		return nil
+	} else if addr == "" {
+		addr = "0.0.0.0"
+	}
+	reply := ss.NewConnectReply(rep, socks.GetAddrAType(addr), addr, port)
+	return ssc.Write(reply.Build())
+}
+
 func (ssc *SSRConn) Read() ([]byte, error) {
 	if len(ssc.buf) > 0 {
 		buf := ssc.buf
 		ssc.buf = nil
 		return buf, nil
 	}
-
-	if data, err := ssc.TConn.Read(); err != nil {
-		return nil, err
-	} else {
-		return ssc.decrypter.Decrypt(data), nil
-	}
+	return ssc.reader.Read(ssc.conn)
 }
 
 func (ssc *SSRConn) Write(data []byte) error {
-	if !ssc.ivSent {
-		ssc.TConn.Write(ssc.iv)
-		ssc.ivSent = true
-	}
-	return ssc.TConn.Write(ssc.encrypter.Encrypt(data))
+	return ssc.writer.Write(ssc.conn, data)
 }
 
 /*
@@ -139,23 +277,45 @@ func (ssc *SSRConn) Write(data []byte) error {
 type SSLConn struct {
 	TConn
 	cipherInfo *cipher.CipherInfo
-	encrypter  cipher.Encrypter
-	decrypter  cipher.Decrypter
+	reader     ssReader
+	writer     ssWriter
 	key        []byte
-	iv         []byte
+	buf        []byte
 }
 
 /* 连接Shadowsocks服务 */
 func SSDial(addr string, port uint16, method, password string) (*SSLConn, error) {
+	return SSProxyDial(nil, addr, port, method, password)
+}
+
+/* 经由上游SOCKS5代理连接Shadowsocks服务, proxy为nil时直接连接 */
+func SSProxyDial(proxy *Socks5Proxy, addr string, port uint16, method, password string) (*SSLConn, error) {
+	return ssDial(proxyDialer(proxy, addr, port), method, password)
+}
+
+/* 经由transport连接Shadowsocks服务 */
+func SSTransportDial(transport Transport, addr string, port uint16, method, password string) (*SSLConn, error) {
+	return ssDial(transportDialer(transport, addr, port), method, password)
+}
+
+func ssDial(dial func() (*Conn, error), method, password string) (*SSLConn, error) {
+	if IsX25519Method(method) {
+		return x25519Dial(dial, method, password)
+	}
 	cipherInfo := cipher.GetCipherInfo(strings.ToLower(method))
 	if cipherInfo == nil {
 		return nil, fmt.Errorf("Method %s Not Found", method)
 	}
-	key := ss.CreateKey(password, cipherInfo.KeySize)
-	iv := cipher.RandKey(cipherInfo.IvSize)
-	encrypter := cipherInfo.EncrypterFunc(key, iv)
+	key, err := createSSKey(cipherInfo, password)
+	if err != nil {
+		return nil, err
+	}
+	reader, writer, err := newSSReadWriter(cipherInfo, key, false, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	c, err := Dial("tcp", fmt.Sprintf("%s:%d", addr, port))
+	c, err := dial()
 	if err != nil {
 		return nil, err
 	}
@@ -164,41 +324,104 @@ func SSDial(addr string, port uint16, method, password string) (*SSLConn, error)
 			conn: c,
 		},
 		cipherInfo: cipherInfo,
-		encrypter:  encrypter,
+		reader:     reader,
+		writer:     writer,
 		key:        key,
-		iv:         iv,
 	}, nil
 }
 
 func (ssc *SSLConn) Start(addr string, port uint16) error {
 	atype := socks.GetAddrAType(addr)
 	req := ss.NewAddressRequest(atype, addr, port)
-	if len(ssc.iv) != 0 {
-		if _, err := ssc.conn.Write(ssc.iv); err != nil {
-			return err
-		}
+	return ssc.Write(req.Build())
+}
+
+/* 请求服务端返回连接目标的结果, 之后用ReadReply读取 */
+func (ssc *SSLConn) StartReply(addr string, port uint16) error {
+	atype := socks.GetAddrAType(addr)
+	req := ss.NewAddressRequest(atype, addr, port)
+	req.REPLY = true
+	return ssc.Write(req.Build())
+}
+
+func (ssc *SSLConn) ReadReply() (*ss.ConnectReply, error) {
+	var rep *ss.ConnectReply
+	buf := ssc.buf
+	ssc.buf = nil
+	err := readMessage(ssc.reader, ssc.conn, buf, func(buf []byte) (err error) {
+		rep, err = ss.ParseConnectReply(buf)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := ssc.Write(req.Build()); err != nil {
+	ssc.buf = rep.BUF
+	return rep, nil
+}
+
+/* 请求服务端监听一个端口 (BIND) */
+func (ssc *SSLConn) StartBind(addr string, port uint16) error {
+	atype := socks.GetAddrAType(addr)
+	req := ss.NewBindRequest(atype, addr, port)
+	return ssc.Write(req.Build())
+}
+
+/* 请求服务端在addr:port上监听 (反向隧道), 之后用ReadReply读取结果 */
+func (ssc *SSLConn) StartReverse(addr string, port uint16) error {
+	atype := socks.GetAddrAType(addr)
+	req := ss.NewReverseRequest(atype, addr, port)
+	return ssc.Write(req.Build())
+}
+
+/* 之后的数据是多路复用的帧 */
+func (ssc *SSLConn) StartMux() error {
+	return ssc.Write(ss.NewMuxRequest().Build())
+}
+
+/* 读取BIND命令中服务端返回的地址, 之后的数据留给Read */
+func (ssc *SSLConn) ReadAddress() (string, uint16, error) {
+	buf := ssc.buf
+	ssc.buf = nil
+	req, err := readAddressRequest(ssc.reader, ssc.conn, buf)
+	if err != nil {
+		return "", 0, err
+	}
+	ssc.buf = req.BUF
+	return req.ADDR, req.PORT, nil
+}
+
+func readAddressRequest(reader ssReader, r io.Reader, buf []byte) (*ss.AddressRequest, error) {
+	var req *ss.AddressRequest
+	err := readMessage(reader, r, buf, func(buf []byte) (err error) {
+		req, err = ss.ParseAddressRequest(buf)
 		return err
+	})
+	return req, err
+}
+
+/* 消息可能被拆分到多次读取中, 读到parse不再返回ErrIncompleteMessage为止 */
+func readMessage(reader ssReader, r io.Reader, buf []byte, parse func([]byte) error) error {
+	for {
+		if err := parse(buf); err != socks.ErrIncompleteMessage {
+			return err
+		}
+		data, err := reader.Read(r)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, data...)
 	}
-	return nil
 }
 
 func (ssc *SSLConn) Write(data []byte) error {
-	return ssc.TConn.Write(ssc.encrypter.Encrypt(data))
+	return ssc.writer.Write(ssc.conn, data)
 }
 
 func (ssc *SSLConn) Read() ([]byte, error) {
-	if ssc.decrypter == nil {
-		ivbuf := make([]byte, ssc.cipherInfo.IvSize)
-		if _, err := ssc.conn.Read(ivbuf); err != nil {
-			return nil, err
-		}
-		ssc.decrypter = ssc.cipherInfo.DecrypterFunc(ssc.key, ivbuf)
-	}
-	if data, err := ssc.TConn.Read(); err != nil {
-		return nil, err
-	} else {
-		return ssc.decrypter.Decrypt(data), nil
+	if len(ssc.buf) > 0 {
+		buf := ssc.buf
+		ssc.buf = nil
+		return buf, nil
 	}
+	return ssc.reader.Read(ssc.conn)
 }
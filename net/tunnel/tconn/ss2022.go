@@ -0,0 +1,311 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	_cipher "crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"galaxy/cipher"
+	"galaxy/protocol/ss"
+	"io"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Shadowsocks 2022 (SIP022)
+ * 请求: [salt][固定长度头][TAG][可变长度头][TAG][长度][TAG][数据][TAG]...
+ *   固定长度头: [类型 0][时间戳 u64][可变长度头长度 u16]
+ *   可变长度头: [目标地址][填充长度 u16][填充][数据]
+ * 响应: [salt][固定长度头][TAG][数据][TAG][长度][TAG][数据][TAG]...
+ *   固定长度头: [类型 1][时间戳 u64][请求的salt][数据长度 u16]
+ */
+
+const (
+	ss2022TypeRequest  = 0
+	ss2022TypeResponse = 1
+
+	/* 每个块的最大长度 */
+	ss2022MaxPayloadSize = 0xFFFF
+	/* 时间戳允许的最大误差 */
+	ss2022MaxTimeDiff = 30
+	/* salt需要保存的时间 */
+	ss2022SaltTTL = 60 * time.Second
+	/* 请求中填充的最大长度 */
+	ss2022MaxPaddingSize = 900
+)
+
+var (
+	errSS2022BadType      = errors.New("Invalid SS2022 Header Type")
+	errSS2022BadTimestamp = errors.New("Invalid SS2022 Timestamp")
+	errSS2022BadSalt      = errors.New("Invalid SS2022 Request Salt")
+	errSS2022BadHeader    = errors.New("Invalid SS2022 Header")
+	errSaltReplayed       = errors.New("Salt Replayed")
+)
+
+/* 检查salt是否重复, 重复时返回false, Replays返回发现的重放次数 */
+type saltFilter interface {
+	Check(salt []byte) bool
+	Replays() uint64
+}
+
+/* SS2022要求服务端记住最近60秒内出现过的salt */
+type ss2022SaltPool struct {
+	lock    sync.Mutex
+	salts   map[string]time.Time
+	ttl     time.Duration
+	last    time.Time
+	replays uint64
+}
+
+func newSS2022SaltPool() *ss2022SaltPool {
+	return &ss2022SaltPool{
+		salts: make(map[string]time.Time),
+		ttl:   ss2022SaltTTL,
+	}
+}
+
+func (p *ss2022SaltPool) Check(salt []byte) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	if now.Sub(p.last) > p.ttl {
+		p.last = now
+		for k, expire := range p.salts {
+			if now.After(expire) {
+				delete(p.salts, k)
+			}
+		}
+	}
+	if expire, ok := p.salts[string(salt)]; ok && now.Before(expire) {
+		atomic.AddUint64(&p.replays, 1)
+		return false
+	}
+	p.salts[string(salt)] = now.Add(p.ttl)
+	return true
+}
+
+func (p *ss2022SaltPool) Replays() uint64 {
+	return atomic.LoadUint64(&p.replays)
+}
+
+func ss2022Timestamp() []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().Unix()))
+	return buf
+}
+
+func ss2022CheckTimestamp(buf []byte) bool {
+	diff := time.Now().Unix() - int64(binary.BigEndian.Uint64(buf))
+	return diff <= ss2022MaxTimeDiff && diff >= -ss2022MaxTimeDiff
+}
+
+/*
+ * 客户端第一次写入的数据是目标地址, 和随机填充一起放在可变长度头中
+ * 服务端第一次写入的数据直接放在固定长度头之后
+ */
+type ss2022Writer struct {
+	aead       _cipher.AEAD
+	salt       []byte
+	nonce      []byte
+	headerSent bool
+	server     bool
+	reader     *ss2022Reader
+}
+
+func newSS2022Writer(info *cipher.CipherInfo, key []byte) (*ss2022Writer, error) {
+	salt := cipher.RandKey(info.IvSize)
+	aead, err := info.NewAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &ss2022Writer{
+		aead:  aead,
+		salt:  salt,
+		nonce: make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (sw *ss2022Writer) seal(buf, data []byte) []byte {
+	buf = sw.aead.Seal(buf, sw.nonce, data, nil)
+	increaseNonce(sw.nonce)
+	return buf
+}
+
+func (sw *ss2022Writer) writeHeader(data []byte) ([]byte, []byte) {
+	buf := append([]byte{}, sw.salt...)
+	var header, payload []byte
+	if sw.server {
+		payload = data
+		if len(payload) > ss2022MaxPayloadSize {
+			payload = payload[:ss2022MaxPayloadSize]
+		}
+		data = data[len(payload):]
+		header = append([]byte{ss2022TypeResponse}, ss2022Timestamp()...)
+		header = append(header, sw.reader.salt...)
+	} else {
+		padding := make([]byte, 2+1+mrand.Intn(ss2022MaxPaddingSize))
+		binary.BigEndian.PutUint16(padding, uint16(len(padding)-2))
+		payload = append(append([]byte{}, data...), padding...)
+		data = nil
+		header = append([]byte{ss2022TypeRequest}, ss2022Timestamp()...)
+	}
+	header = append(header, byte(len(payload)>>8), byte(len(payload)))
+	buf = sw.seal(buf, header)
+	buf = sw.seal(buf, payload)
+	return buf, data
+}
+
+func (sw *ss2022Writer) Write(w io.Writer, data []byte) error {
+	var buf []byte
+	if !sw.headerSent {
+		sw.headerSent = true
+		buf, data = sw.writeHeader(data)
+	}
+	for len(data) > 0 {
+		size := len(data)
+		if size > ss2022MaxPayloadSize {
+			size = ss2022MaxPayloadSize
+		}
+		var sizebuf [2]byte
+		binary.BigEndian.PutUint16(sizebuf[:], uint16(size))
+		buf = sw.seal(buf, sizebuf[:])
+		buf = sw.seal(buf, data[:size])
+		data = data[size:]
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+/*
+ * 服务端读取请求头时检查salt是否重复,
+ * 客户端读取响应头时检查其中的salt是否是自己发送的
+ */
+type ss2022Reader struct {
+	info   *cipher.CipherInfo
+	key    []byte
+	aead   _cipher.AEAD
+	nonce  []byte
+	salt   []byte
+	server bool
+	filter saltFilter
+	writer *ss2022Writer
+}
+
+func (sr *ss2022Reader) open(r io.Reader, size int) ([]byte, error) {
+	buf := make([]byte, size+sr.aead.Overhead())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	data, err := sr.aead.Open(buf[:0], sr.nonce, buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	increaseNonce(sr.nonce)
+	return data, nil
+}
+
+func (sr *ss2022Reader) readHeader(r io.Reader) ([]byte, error) {
+	salt := make([]byte, sr.info.IvSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, err
+	}
+	aead, err := sr.info.NewAEAD(sr.key, salt)
+	if err != nil {
+		return nil, err
+	}
+	sr.aead = aead
+	sr.nonce = make([]byte, aead.NonceSize())
+
+	size := 1 + 8 + 2
+	if !sr.server {
+		size += len(sr.writer.salt)
+	}
+	header, err := sr.open(r, size)
+	if err != nil {
+		return nil, err
+	}
+	/* 认证通过之后再记录salt, 避免被随机数据填满 */
+	if sr.server && !sr.filter.Check(salt) {
+		return nil, errSaltReplayed
+	}
+	sr.salt = salt
+
+	if sr.server && header[0] != ss2022TypeRequest {
+		return nil, errSS2022BadType
+	} else if !sr.server && header[0] != ss2022TypeResponse {
+		return nil, errSS2022BadType
+	} else if !ss2022CheckTimestamp(header[1:9]) {
+		return nil, errSS2022BadTimestamp
+	} else if !sr.server && subtle.ConstantTimeCompare(header[9:size-2], sr.writer.salt) != 1 {
+		return nil, errSS2022BadSalt
+	}
+	data, err := sr.open(r, int(binary.BigEndian.Uint16(header[size-2:])))
+	if err != nil || !sr.server {
+		return data, err
+	}
+
+	/* 去掉填充, 返回目标地址和数据 */
+	req, err := ss.ParseAddressRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	rest := req.BUF
+	if len(rest) < 2 {
+		return nil, errSS2022BadHeader
+	}
+	addrlen := len(data) - len(rest)
+	padding := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+padding {
+		return nil, errSS2022BadHeader
+	}
+	return append(data[:addrlen:addrlen], rest[2+padding:]...), nil
+}
+
+func (sr *ss2022Reader) Read(r io.Reader) ([]byte, error) {
+	if sr.aead == nil {
+		return sr.readHeader(r)
+	}
+	sizebuf, err := sr.open(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	return sr.open(r, int(binary.BigEndian.Uint16(sizebuf)))
+}
+
+/* 创建一对关联的读写器, 服务端需要提供salt过滤器 */
+func newSS2022ReadWriter(info *cipher.CipherInfo, key []byte, server bool, filter saltFilter) (ssReader, ssWriter, error) {
+	writer, err := newSS2022Writer(info, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := &ss2022Reader{
+		info:   info,
+		key:    key,
+		server: server,
+		filter: filter,
+		writer: writer,
+	}
+	writer.server = server
+	writer.reader = reader
+	return reader, writer, nil
+}
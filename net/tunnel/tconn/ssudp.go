@@ -0,0 +1,199 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"errors"
+	"fmt"
+	"galaxy/cipher"
+	"galaxy/protocol/ss"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Shadowsocks UDP, 每个数据包单独加密
+ * 流加密: [IV][加密的(目标地址 + 数据)]
+ * AEAD加密: [salt][加密的(目标地址 + 数据)][TAG], nonce为0
+ */
+
+const (
+	/* UDP数据包的最大长度 */
+	ssMaxPacketSize = 64 * 1024
+)
+
+var (
+	errPacketTooShort = errors.New("Packet Too Short")
+)
+
+type ssPacketCipher struct {
+	info *cipher.CipherInfo
+	key  []byte
+}
+
+/* 判断加密方式是否支持UDP */
+func IsUDPSupported(method string) bool {
+	info := cipher.GetCipherInfo(strings.ToLower(method))
+	return info != nil && !info.SS2022
+}
+
+/* SS2022和握手方式的加密不支持UDP */
+func newSSPacketCipher(method, password string) (*ssPacketCipher, error) {
+	if !IsUDPSupported(method) {
+		return nil, fmt.Errorf("Method %s Not Supported For UDP", method)
+	}
+	info := cipher.GetCipherInfo(strings.ToLower(method))
+	return &ssPacketCipher{
+		info: info,
+		key:  ss.CreateKey(password, info.KeySize),
+	}, nil
+}
+
+func (c *ssPacketCipher) Encrypt(data []byte) ([]byte, error) {
+	iv := cipher.RandKey(c.info.IvSize)
+	if c.info.IsAEAD() {
+		aead, err := c.info.NewAEAD(c.key, iv)
+		if err != nil {
+			return nil, err
+		}
+		return aead.Seal(iv, make([]byte, aead.NonceSize()), data, nil), nil
+	}
+	encrypter, err := c.info.EncrypterFunc(c.key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, encrypter.Encrypt(data)...), nil
+}
+
+func (c *ssPacketCipher) Decrypt(data []byte) ([]byte, error) {
+	if len(data) < c.info.IvSize {
+		return nil, errPacketTooShort
+	}
+	iv, data := data[:c.info.IvSize], data[c.info.IvSize:]
+	if c.info.IsAEAD() {
+		aead, err := c.info.NewAEAD(c.key, iv)
+		if err != nil {
+			return nil, err
+		}
+		return aead.Open(data[:0], make([]byte, aead.NonceSize()), data, nil)
+	}
+	decrypter, err := c.info.DecrypterFunc(c.key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return decrypter.Decrypt(data), nil
+}
+
+/* Shadowsocks UDP连接, 客户端的remote是服务端地址 */
+type SSPacketConn struct {
+	conn   net.PacketConn
+	cipher *ssPacketCipher
+	remote net.Addr
+}
+
+/* 客户端, 所有数据包都发送给服务端 */
+func DialSSPacket(addr string, port uint16, method, password string) (*SSPacketConn, error) {
+	c, err := newSSPacketCipher(method, password)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := net.ResolveUDPAddr("udp", net.JoinHostPort(addr, strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", "")
+	if err != nil {
+		return nil, err
+	}
+	return &SSPacketConn{
+		conn:   conn,
+		cipher: c,
+		remote: remote,
+	}, nil
+}
+
+/* 服务端 */
+func ListenSSPacket(address, method, password string) (*SSPacketConn, error) {
+	c, err := newSSPacketCipher(method, password)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &SSPacketConn{
+		conn:   conn,
+		cipher: c,
+	}, nil
+}
+
+func (c *SSPacketConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *SSPacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *SSPacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+/* 读取一个数据包, 目标地址之后的数据在BUF中; 无法解密的数据包被丢弃 */
+func (c *SSPacketConn) ReadFrom() (*ss.AddressRequest, net.Addr, error) {
+	buf := make([]byte, ssMaxPacketSize)
+	for {
+		n, from, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, nil, err
+		} else if c.remote != nil && from.String() != c.remote.String() {
+			continue
+		}
+		data, err := c.cipher.Decrypt(buf[:n])
+		if err != nil {
+			continue
+		}
+		req, err := ss.ParseAddressRequest(data)
+		if err != nil {
+			continue
+		}
+		return req, from, nil
+	}
+}
+
+func (c *SSPacketConn) WriteTo(req *ss.AddressRequest, to net.Addr) error {
+	data, err := c.cipher.Encrypt(append(req.Build(), req.BUF...))
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.WriteTo(data, to)
+	return err
+}
+
+/* 客户端使用 */
+func (c *SSPacketConn) Read() (*ss.AddressRequest, error) {
+	req, _, err := c.ReadFrom()
+	return req, err
+}
+
+func (c *SSPacketConn) Write(req *ss.AddressRequest) error {
+	return c.WriteTo(req, c.remote)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"testing"
+)
+
+func testSSPacket(t *testing.T, method string) {
+	server, err := ListenSSPacket("127.0.0.1:0", method, "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.LocalAddr().(*net.UDPAddr)
+	client, err := DialSSPacket("127.0.0.1", uint16(addr.Port), method, "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	req := ss.NewAddressRequest(socks.ATypeIPv4, "8.8.8.8", 53)
+	req.BUF = []byte("query")
+	if err := client.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	received, from, err := server.ReadFrom()
+	if err != nil {
+		t.Fatal(err)
+	} else if received.ADDR != "8.8.8.8" || received.PORT != 53 || !bytes.Equal(received.BUF, req.BUF) {
+		t.Fatalf("%s: Wrong Request", method)
+	}
+
+	received.BUF = []byte("answer")
+	if err := server.WriteTo(received, from); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	} else if reply.ADDR != "8.8.8.8" || !bytes.Equal(reply.BUF, []byte("answer")) {
+		t.Fatalf("%s: Wrong Reply", method)
+	}
+}
+
+func TestSSPacket(t *testing.T) {
+	testSSPacket(t, "aes-256-cfb")
+	testSSPacket(t, "chacha20-ietf")
+	testSSPacket(t, "aes-128-gcm")
+	testSSPacket(t, "chacha20-ietf-poly1305")
+}
+
+func TestSSPacketTampered(t *testing.T) {
+	c, _ := newSSPacketCipher("aes-256-gcm", "galaxy")
+	data, err := c.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if _, err := c.Decrypt(data); err == nil {
+		t.Fatal("Tampered Packet Accepted")
+	}
+	if _, err := newSSPacketCipher("2022-blake3-aes-128-gcm", "galaxy"); err == nil {
+		t.Fatal("SS2022 Method Accepted")
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"errors"
+	"fmt"
+	"galaxy/cipher"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * 多用户Shadowsocks服务, 所有用户共用一个端口
+ * 依次用每个用户的密钥解密连接开头的数据来识别用户:
+ * AEAD加密尝试解密第一个块, 流加密检查解密出的目标地址是否合法
+ * 流加密可能误判, 有多个流加密用户匹配时拒绝连接
+ */
+
+const (
+	ssMatchNo = iota
+	ssMatchYes
+	ssMatchMore
+
+	/* 识别用户时最多读取的数据长度 */
+	ssIdentifyMaxSize = 1024
+	/* 识别用户的时间限制 */
+	ssIdentifyTimeout = 30 * time.Second
+	/* 已经有流加密用户匹配时, 等待AEAD候选的时间 */
+	ssIdentifyWait = 500 * time.Millisecond
+)
+
+var (
+	errUserNotFound    = errors.New("User Not Found")
+	errUserAmbiguous   = errors.New("Multiple Users Matched")
+	errNotMultiUser    = errors.New("Not A Multi-User Listener")
+	errUserExists      = errors.New("User Already Exists")
+	errInvalidUserName = errors.New("Invalid User Name")
+)
+
+type SSUser struct {
+	Method   string
+	Password string
+}
+
+type ssUser struct {
+	id   string
+	info *cipher.CipherInfo
+	key  []byte
+}
+
+func newSSUser(id string, user SSUser) (*ssUser, error) {
+	if id == "" {
+		return nil, errInvalidUserName
+	}
+	info := cipher.GetCipherInfo(strings.ToLower(user.Method))
+	if info == nil {
+		return nil, fmt.Errorf("Method %s Not Found", user.Method)
+	}
+	key, err := createSSKey(info, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &ssUser{
+		id:   id,
+		info: info,
+		key:  key,
+	}, nil
+}
+
+/* 判断数据是否是用这个用户的密钥加密的, 数据不够时返回ssMatchMore */
+func (u *ssUser) match(buf []byte) int {
+	info := u.info
+	if len(buf) < info.IvSize {
+		return ssMatchMore
+	}
+	iv, data := buf[:info.IvSize], buf[info.IvSize:]
+	if info.IsAEAD() {
+		aead, err := info.NewAEAD(u.key, iv)
+		if err != nil {
+			return ssMatchNo
+		}
+		size := 2
+		if info.SS2022 {
+			size = 1 + 8 + 2
+		}
+		size += aead.Overhead()
+		if len(data) < size {
+			return ssMatchMore
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := aead.Open(nil, nonce, data[:size], nil); err != nil {
+			return ssMatchNo
+		}
+		return ssMatchYes
+	}
+	decrypter, err := info.DecrypterFunc(u.key, iv)
+	if err != nil {
+		return ssMatchNo
+	}
+	return matchAddress(decrypter.Decrypt(append([]byte{}, data...)))
+}
+
+/* 检查数据开头是不是合法的目标地址 */
+func matchAddress(buf []byte) int {
+	if len(buf) < 2 {
+		return ssMatchMore
+	}
+	atype := buf[0] &^ (ss.FlagBind | ss.FlagReply)
+	if buf[0]&(ss.FlagBind|ss.FlagReply) == ss.FlagBind|ss.FlagReply {
+		/* BIND不会等待连接结果 */
+		return ssMatchNo
+	} else if buf[0] == ss.ATypeCommand {
+		/* 扩展命令之后是没有标志位的地址 */
+		if buf[1] != ss.CMDReverse && buf[1] != ss.CMDMux {
+			return ssMatchNo
+		}
+		buf = buf[2:]
+		if len(buf) < 2 {
+			return ssMatchMore
+		}
+		atype = buf[0]
+	}
+	size := 0
+	switch atype {
+	case socks.ATypeIPv4:
+		size = 1 + 4 + 2
+	case socks.ATypeIPv6:
+		size = 1 + 16 + 2
+	case socks.ATypeDomain:
+		size = 1 + 1 + int(buf[1]) + 2
+		if buf[1] == 0 {
+			return ssMatchNo
+		}
+		for i := 2; i < len(buf) && i < size-2; i++ {
+			c := buf[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return ssMatchNo
+			}
+		}
+	default:
+		return ssMatchNo
+	}
+	if len(buf) < size {
+		return ssMatchMore
+	}
+	return ssMatchYes
+}
+
+/*
+ * 用户变化时重新排序, AEAD用户优先, 流加密容易误判
+ * 每次生成新的列表, 正在识别的连接继续使用旧的列表; 需要持有userLock
+ */
+func (l *SSListener) sortUsers() {
+	users := make([]*ssUser, 0, len(l.users))
+	for _, user := range l.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].info.IsAEAD() != users[j].info.IsAEAD() {
+			return users[i].info.IsAEAD()
+		}
+		return users[i].id < users[j].id
+	})
+	l.userList = users
+}
+
+func (l *SSListener) listUsers() []*ssUser {
+	l.userLock.RLock()
+	defer l.userLock.RUnlock()
+	return l.userList
+}
+
+/*
+ * 读取连接开头的数据识别用户, 返回用户和已经读取的数据
+ * AEAD用户认证通过就可以确定; 流加密用户要检查所有候选, 有多个匹配时拒绝
+ * 还有AEAD候选需要更多数据时继续读取, 以免分段到达的AEAD连接被误认为流加密用户;
+ * 这时最多再等待ssIdentifyWait, 客户端不再发送数据时使用匹配的流加密用户
+ * 只有流加密候选需要更多数据时, 它们被当作不匹配, 以免等待客户端
+ */
+func (l *SSListener) identify(c net.Conn) (*ssUser, []byte, error) {
+	c.SetReadDeadline(time.Now().Add(ssIdentifyTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	pending := l.listUsers()
+	var matched []*ssUser
+	waiting := false
+	buf := make([]byte, 0, ssIdentifyMaxSize)
+	for len(pending) > 0 && len(buf) < cap(buf) {
+		n, err := c.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() && len(matched) > 0 {
+				break
+			}
+			return nil, nil, err
+		}
+		var more []*ssUser
+		aead := false
+		for _, user := range pending {
+			switch user.match(buf) {
+			case ssMatchYes:
+				if user.info.IsAEAD() {
+					return user, buf, nil
+				}
+				matched = append(matched, user)
+			case ssMatchMore:
+				more = append(more, user)
+				aead = aead || user.info.IsAEAD()
+			}
+		}
+		if len(matched) > 1 {
+			return nil, nil, errUserAmbiguous
+		} else if len(matched) == 1 && !aead {
+			return matched[0], buf, nil
+		} else if len(matched) == 1 {
+			/* 只继续等待AEAD候选 */
+			pending = pending[:0:0]
+			for _, user := range more {
+				if user.info.IsAEAD() {
+					pending = append(pending, user)
+				}
+			}
+			if !waiting {
+				waiting = true
+				c.SetReadDeadline(time.Now().Add(ssIdentifyWait))
+			}
+			continue
+		}
+		pending = more
+	}
+	if len(matched) == 1 {
+		return matched[0], buf, nil
+	}
+	return nil, nil, errUserNotFound
+}
+
+/* 添加用户, 不需要重启服务 */
+func (l *SSListener) AddUser(id string, user SSUser) error {
+	if l.users == nil {
+		return errNotMultiUser
+	}
+	u, err := newSSUser(id, user)
+	if err != nil {
+		return err
+	}
+	l.userLock.Lock()
+	defer l.userLock.Unlock()
+	if _, ok := l.users[id]; ok {
+		return errUserExists
+	}
+	l.users[id] = u
+	l.sortUsers()
+	return nil
+}
+
+/* 删除用户, 已经建立的连接不受影响 */
+func (l *SSListener) RemoveUser(id string) {
+	if l.users == nil {
+		return
+	}
+	l.userLock.Lock()
+	defer l.userLock.Unlock()
+	delete(l.users, id)
+	l.sortUsers()
+}
+
+/* 已经读取的数据在之后的Read中先返回 */
+type bufferedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
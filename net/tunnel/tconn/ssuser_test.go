@@ -0,0 +1,229 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"galaxy/cipher"
+	"galaxy/protocol/socks"
+	"galaxy/protocol/ss"
+	"net"
+	"testing"
+	"time"
+)
+
+func testMultiUser(t *testing.T, transport Transport, l *SSListener, id string, user SSUser) {
+	port := uint16(l.netListener.Addr().(*net.TCPAddr).Port)
+	go func() {
+		c, err := SSTransportDial(transport, "127.0.0.1", port, user.Method, user.Password)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Start("example.com", 443)
+		c.Write([]byte("hello " + id))
+		c.Read()
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	addr, port, err := c.Start()
+	if err != nil {
+		t.Fatalf("%s: %v", id, err)
+	} else if addr != "example.com" || port != 443 {
+		t.Fatalf("%s: Wrong Address %s:%d", id, addr, port)
+	} else if c.User() != id {
+		t.Fatalf("%s: Wrong User %s", id, c.User())
+	}
+	var data []byte
+	for len(data) < len("hello "+id) {
+		buf, err := c.Read()
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		data = append(data, buf...)
+	}
+	if !bytes.Equal(data, []byte("hello "+id)) {
+		t.Fatalf("%s: Wrong Data", id)
+	}
+	c.Write([]byte("bye"))
+}
+
+func TestMultiUser(t *testing.T) {
+	users := map[string]SSUser{
+		"alice": SSUser{"aes-256-gcm", "alice"},
+		"bob":   SSUser{"aes-256-gcm", "bob"},
+		"carol": SSUser{"chacha20-ietf", "carol"},
+		"dave":  SSUser{"chacha20-ietf-poly1305", "dave"},
+		"erin":  SSUser{"2022-blake3-aes-128-gcm", base64.StdEncoding.EncodeToString(cipher.RandKey(16))},
+	}
+	l, err := NewSSMultiListener("127.0.0.1:0", users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for id, user := range users {
+		testMultiUser(t, TCPTransport, l, id, user)
+	}
+
+	/* 运行时添加和删除用户 */
+	frank := SSUser{"xchacha20-ietf-poly1305", "frank"}
+	if err := l.AddUser("frank", frank); err != nil {
+		t.Fatal(err)
+	} else if err := l.AddUser("frank", frank); err == nil {
+		t.Fatal("Duplicate User Accepted")
+	}
+	testMultiUser(t, TCPTransport, l, "frank", frank)
+
+	/* 流加密可能误判, 只留下AEAD用户 */
+	l.RemoveUser("frank")
+	l.RemoveUser("carol")
+	go func() {
+		port := uint16(l.netListener.Addr().(*net.TCPAddr).Port)
+		if c, err := SSDial("127.0.0.1", port, frank.Method, frank.Password); err == nil {
+			defer c.Close()
+			c.Start("example.com", 443)
+			c.Read()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, _, err := c.Start(); err != errUserNotFound {
+		t.Fatalf("Removed User Accepted: %v", err)
+	}
+}
+
+func TestMultiUserWebSocket(t *testing.T) {
+	users := map[string]SSUser{
+		"alice": SSUser{"aes-256-gcm", "alice"},
+		"carol": SSUser{"chacha20-ietf", "carol"},
+	}
+	transport := NewWebSocketTransport("/ws", "")
+	l, err := NewSSMultiTransportListener(transport, "127.0.0.1:0", users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for id, user := range users {
+		testMultiUser(t, transport, l, id, user)
+	}
+}
+
+func TestMatchAddress(t *testing.T) {
+	if matchAddress([]byte{3, 11, 'e', 'x', 'a'}) != ssMatchMore {
+		t.Fatal("Partial Address Not Detected")
+	} else if matchAddress([]byte{3, 3, 'a', 0, 'c', 0, 80}) != ssMatchNo {
+		t.Fatal("Invalid Domain Accepted")
+	} else if matchAddress([]byte{1, 127, 0, 0, 1, 0, 80}) != ssMatchYes {
+		t.Fatal("Valid Address Rejected")
+	} else if matchAddress([]byte{9, 1}) != ssMatchNo {
+		t.Fatal("Invalid Address Type Accepted")
+	} else if matchAddress([]byte{1 | ss.FlagBind | ss.FlagReply, 127, 0, 0, 1, 0, 80}) != ssMatchNo {
+		t.Fatal("Impossible Flags Accepted")
+	} else if matchAddress(ss.NewMuxRequest().Build()) != ssMatchYes {
+		t.Fatal("Mux Request Rejected")
+	} else if matchAddress([]byte{ss.ATypeCommand, ss.CMDMux, 1 | ss.FlagBind, 0, 0, 0, 0, 0, 0}) != ssMatchNo {
+		t.Fatal("Flags After Command Accepted")
+	} else if matchAddress([]byte{ss.ATypeCommand, 1, 1, 0, 0, 0, 0, 0, 0}) != ssMatchNo {
+		t.Fatal("Invalid Command Accepted")
+	}
+}
+
+/* 多个流加密用户都能解密出合法的地址时拒绝连接 */
+func TestMultiUserAmbiguous(t *testing.T) {
+	users := map[string]SSUser{
+		"alice": SSUser{"aes-256-cfb", "same"},
+		"bob":   SSUser{"aes-256-cfb", "same"},
+	}
+	l, err := NewSSMultiListener("127.0.0.1:0", users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		port := uint16(l.netListener.Addr().(*net.TCPAddr).Port)
+		if c, err := SSDial("127.0.0.1", port, "aes-256-cfb", "same"); err == nil {
+			defer c.Close()
+			c.Start("127.0.0.1", 80)
+			c.Read()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, _, err := c.Start(); err != errUserAmbiguous {
+		t.Fatalf("Ambiguous User Accepted: %v", err)
+	}
+}
+
+/* 流加密用户先匹配时, 继续等待分段到达的AEAD数据 */
+func TestMultiUserFragmented(t *testing.T) {
+	users := map[string]SSUser{
+		"alice": SSUser{"aes-256-gcm", "alice"},
+		"carol": SSUser{"none", ""},
+	}
+	l, err := NewSSMultiListener("127.0.0.1:0", users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	/* 盐的开头对none用户是合法的IPv4地址 */
+	info := cipher.GetCipherInfo("aes-256-gcm")
+	key, _ := createSSKey(info, "alice")
+	salt := cipher.RandKey(info.IvSize)
+	salt[0] = socks.ATypeIPv4
+	aead, err := info.NewAEAD(key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := aead.Seal(nil, make([]byte, aead.NonceSize()), []byte{0, 7}, nil)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		c2.Write(salt)
+		time.Sleep(100 * time.Millisecond)
+		c2.Write(size)
+	}()
+	if user, _, err := l.identify(c1); err != nil {
+		t.Fatal(err)
+	} else if user.id != "alice" {
+		t.Fatalf("Wrong User %s", user.id)
+	}
+
+	/* 客户端发送地址之后等待服务端, 不能一直等待AEAD候选 */
+	c3, c4 := net.Pipe()
+	defer c3.Close()
+	defer c4.Close()
+	go c4.Write([]byte{socks.ATypeIPv4, 127, 0, 0, 1, 0, 80})
+	if user, _, err := l.identify(c3); err != nil {
+		t.Fatal(err)
+	} else if user.id != "carol" {
+		t.Fatalf("Wrong User %s", user.id)
+	}
+}
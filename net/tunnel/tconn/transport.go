@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"net"
+	"strconv"
+)
+
+/*
+ * 本地和服务端之间的传输层, Shadowsocks的数据流在它之上传输
+ * 默认是TCP, 也可以是WebSocket等
+ */
+type Transport interface {
+	Dial(address string) (net.Conn, error)
+	Listen(address string) (net.Listener, error)
+}
+
+type tcpTransport struct{}
+
+var (
+	/* 直接使用TCP */
+	TCPTransport Transport = tcpTransport{}
+)
+
+func (tcpTransport) Dial(address string) (net.Conn, error) {
+	return net.Dial("tcp", address)
+}
+
+func (tcpTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+/* 经由transport连接服务端 */
+func transportDialer(transport Transport, addr string, port uint16) func() (*Conn, error) {
+	return func() (*Conn, error) {
+		c, err := transport.Dial(net.JoinHostPort(addr, strconv.Itoa(int(port))))
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(c), nil
+	}
+}
+
+/* 经由上游SOCKS5代理连接服务端, proxy为nil时直接连接 */
+func proxyDialer(proxy *Socks5Proxy, addr string, port uint16) func() (*Conn, error) {
+	return func() (*Conn, error) {
+		return dialTCP(proxy, addr, port)
+	}
+}
@@ -0,0 +1,380 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	wsGUID    = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsVersion = "13"
+
+	wsOpContinuation = byte(0x0)
+	wsOpText         = byte(0x1)
+	wsOpBinary       = byte(0x2)
+	wsOpClose        = byte(0x8)
+	wsOpPing         = byte(0x9)
+	wsOpPong         = byte(0xA)
+
+	wsMaxControlSize = 125
+)
+
+var (
+	errWSHandshake = errors.New("WebSocket Handshake Failed")
+	errWSProtocol  = errors.New("WebSocket Protocol Error")
+)
+
+/*
+ * WebSocket传输层, 可以部署在HTTP反向代理之后
+ * 客户端在Dial时完成握手, 服务端在第一次读写时完成握手
+ * 数据都用binary帧发送
+ */
+type WebSocketTransport struct {
+	path string
+	host string
+}
+
+/* path为空时是"/"; host是握手请求中的Host, 为空时使用服务端地址 */
+func NewWebSocketTransport(path, host string) *WebSocketTransport {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return &WebSocketTransport{
+		path: path,
+		host: host,
+	}
+}
+
+func (t *WebSocketTransport) Dial(address string) (net.Conn, error) {
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	host := t.host
+	if host == "" {
+		host = address
+	}
+	ws, err := wsClientHandshake(c, t.path, host)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (t *WebSocketTransport) Listen(address string) (net.Listener, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &wsListener{
+		Listener: l,
+		path:     t.path,
+	}, nil
+}
+
+type wsListener struct {
+	net.Listener
+	path string
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ws := newWSConn(c, bufio.NewReader(c), false)
+	ws.handshake = func() error {
+		return ws.serverHandshake(l.path)
+	}
+	return ws, nil
+}
+
+/* WebSocket连接, Read和Write的是binary帧中的数据 */
+type wsConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	client    bool
+	handshake func() error
+	once      sync.Once
+	herr      error
+	ready     atomic.Bool
+	wlock     sync.Mutex
+
+	eof     bool
+	remain  int64
+	mask    []byte
+	maskPos int
+}
+
+func newWSConn(c net.Conn, reader *bufio.Reader, client bool) *wsConn {
+	return &wsConn{
+		Conn:   c,
+		reader: reader,
+		client: client,
+	}
+}
+
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func wsClientHandshake(c net.Conn, path, host string) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	if _, err := fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: %s\r\n\r\n", path, host, key, wsVersion); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(c)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%v: %s", errWSHandshake, resp.Status)
+	} else if resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, errWSHandshake
+	}
+	ws := newWSConn(c, reader, true)
+	ws.ready.Store(true)
+	return ws, nil
+}
+
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *wsConn) writeStatus(code int) {
+	fmt.Fprintf(c.Conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code))
+}
+
+func (c *wsConn) serverHandshake(path string) error {
+	req, err := http.ReadRequest(c.reader)
+	if err != nil {
+		return err
+	}
+	if req.URL.Path != path {
+		c.writeStatus(http.StatusNotFound)
+		return errWSHandshake
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || key == "" ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") {
+		c.writeStatus(http.StatusBadRequest)
+		return errWSHandshake
+	} else if req.Header.Get("Sec-WebSocket-Version") != wsVersion {
+		fmt.Fprintf(c.Conn, "HTTP/1.1 %d %s\r\nSec-WebSocket-Version: %s\r\nContent-Length: 0\r\n\r\n", http.StatusUpgradeRequired, http.StatusText(http.StatusUpgradeRequired), wsVersion)
+		return errWSHandshake
+	}
+	if _, err := fmt.Fprintf(c.Conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAcceptKey(key)); err != nil {
+		return err
+	}
+	c.ready.Store(true)
+	return nil
+}
+
+func (c *wsConn) doHandshake() error {
+	c.once.Do(func() {
+		if c.handshake != nil {
+			c.herr = c.handshake()
+		}
+	})
+	return c.herr
+}
+
+/* 客户端发送的帧必须有掩码, 服务端发送的帧不能有掩码 */
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | op
+	size := len(payload)
+	switch {
+	case size < 126:
+		header[1] = byte(size)
+	case size <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(size))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(size))
+	}
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		header = append(header, mask...)
+		masked := make([]byte, size)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	_, err := c.Conn.Write(append(header, payload...))
+	return err
+}
+
+/*
+ * 读取帧头, 控制帧在这里处理
+ * 先Peek整个帧头(控制帧包括数据)再Discard, 读取超时之后可以继续读取
+ */
+func (c *wsConn) readHeader() error {
+	header, err := c.reader.Peek(2)
+	if err != nil {
+		return err
+	}
+	op := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	size := int64(header[1] & 0x7F)
+	hsize := 2
+	if size == 126 {
+		hsize += 2
+	} else if size == 127 {
+		hsize += 8
+	}
+	if masked {
+		hsize += 4
+	}
+	if header, err = c.reader.Peek(hsize); err != nil {
+		return err
+	}
+	if size == 126 {
+		size = int64(binary.BigEndian.Uint16(header[2:4]))
+	} else if size == 127 {
+		size = int64(binary.BigEndian.Uint64(header[2:10]))
+		if size < 0 {
+			return errWSProtocol
+		}
+	}
+	if masked == c.client {
+		return errWSProtocol
+	}
+	var mask []byte
+	if masked {
+		mask = append([]byte{}, header[hsize-4:hsize]...)
+	}
+
+	switch op {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		c.reader.Discard(hsize)
+		c.remain = size
+		c.mask = mask
+		c.maskPos = 0
+		return nil
+	case wsOpClose, wsOpPing, wsOpPong:
+		if size > wsMaxControlSize {
+			return errWSProtocol
+		}
+	default:
+		return errWSProtocol
+	}
+	frame, err := c.reader.Peek(hsize + int(size))
+	if err != nil {
+		return err
+	}
+	payload := append([]byte{}, frame[hsize:]...)
+	c.reader.Discard(hsize + int(size))
+	if mask != nil {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	switch op {
+	case wsOpPing:
+		return c.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		c.eof = true
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.writeFrame(wsOpClose, payload)
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	if err := c.doHandshake(); err != nil {
+		return 0, err
+	}
+	for c.remain == 0 {
+		if c.eof {
+			return 0, io.EOF
+		} else if err := c.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.reader.Read(p)
+	if c.mask != nil {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remain -= int64(n)
+	return n, err
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.doHandshake(); err != nil {
+		return 0, err
+	}
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+/* 握手完成之后先发送close帧 */
+func (c *wsConn) Close() error {
+	if c.ready.Load() {
+		c.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	transport := NewWebSocketTransport("ws", "galaxy.example")
+	l, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	c, err := transport.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	/* 覆盖7位, 16位和64位的长度 */
+	for _, size := range []int{1, 125, 126, 70000} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		if _, err := c.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, data) {
+			t.Fatalf("Wrong Data Of Size %d", size)
+		}
+	}
+
+	/* 控制帧不影响数据, 服务端回复的pong被跳过 */
+	if err := c.(*wsConn).writeFrame(wsOpPing, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "x" {
+		t.Fatalf("Unexpected Data %q", buf)
+	}
+}
+
+func TestWebSocketPath(t *testing.T) {
+	l, err := NewWebSocketTransport("/ws", "").Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Read(make([]byte, 1))
+			c.Close()
+		}
+	}()
+	if _, err := NewWebSocketTransport("/other", "").Dial(l.Addr().String()); err == nil {
+		t.Fatal("Wrong Path Accepted")
+	}
+
+	/* 不是WebSocket的请求 */
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "HTTP/1.1 400" {
+		t.Fatalf("Unexpected Response %q", buf)
+	}
+}
+
+/* 帧头读到一半超时之后可以继续读取 */
+func TestWebSocketReadTimeout(t *testing.T) {
+	transport := NewWebSocketTransport("/ws", "")
+	l, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	/* 服务端在第一次Read时握手 */
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		c.Read(make([]byte, 1))
+		accepted <- c
+	}()
+	c, err := transport.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("a"))
+	s := <-accepted
+	if s == nil {
+		t.Fatal("Accept Failed")
+	}
+	defer s.Close()
+	buf := make([]byte, 16)
+
+	frame := []byte{0x80 | wsOpBinary, 0x80 | 5, 1, 2, 3, 4}
+	for i, b := range []byte("hello") {
+		frame = append(frame, b^frame[2+i%4])
+	}
+	raw := c.(*wsConn).Conn
+	raw.Write(frame[:3])
+	s.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := s.Read(buf); err == nil {
+		t.Fatal("Read Not Timed Out")
+	}
+	raw.Write(frame[3:])
+	s.SetReadDeadline(time.Time{})
+	if _, err := io.ReadFull(s, buf[:5]); err != nil {
+		t.Fatal(err)
+	} else if string(buf[:5]) != "hello" {
+		t.Fatalf("Unexpected Data %q", buf[:5])
+	}
+}
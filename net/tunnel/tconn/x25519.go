@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"galaxy/cipher"
+	"galaxy/protocol/ss"
+	"io"
+	"strings"
+)
+
+/*
+ * 前向安全的X25519握手, 方法名为 x25519-<AEAD加密方式>, 如 x25519-aes-256-gcm
+ * 双方用临时X25519密钥交换得到会话密钥, 用密码派生的PSK计算HMAC认证对方的公钥
+ * 客户端: [公钥][HMAC(psk, "galaxy x25519 client" + 客户端公钥)]
+ * 服务端: [公钥][HMAC(psk, "galaxy x25519 server" + 客户端公钥 + 服务端公钥)]
+ * 之后每个方向使用各自的密钥和salt, 和AEAD加密的数据格式相同, 但不发送salt
+ */
+
+const (
+	x25519MethodPrefix = "x25519-"
+	x25519KeySize      = 32
+	x25519HelloSize    = x25519KeySize + sha256.Size
+)
+
+var (
+	x25519ClientLabel  = []byte("galaxy x25519 client")
+	x25519ServerLabel  = []byte("galaxy x25519 server")
+	x25519SessionLabel = []byte("galaxy x25519 session")
+
+	errX25519Auth = errors.New("X25519 Handshake Authentication Failed")
+)
+
+/* x25519握手只有galaxy的服务端支持 */
+func IsX25519Method(method string) bool {
+	return strings.HasPrefix(strings.ToLower(method), x25519MethodPrefix)
+}
+
+/* 只支持AEAD加密方式 */
+func getX25519CipherInfo(method string) (*cipher.CipherInfo, error) {
+	name := strings.TrimPrefix(strings.ToLower(method), x25519MethodPrefix)
+	info := cipher.GetCipherInfo(name)
+	if info == nil {
+		return nil, fmt.Errorf("Method %s Not Found", method)
+	} else if !info.IsAEAD() || info.SS2022 {
+		return nil, fmt.Errorf("Method %s Not Supported", method)
+	}
+	return info, nil
+}
+
+func x25519MAC(psk []byte, label []byte, keys ...[]byte) []byte {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write(label)
+	for _, key := range keys {
+		mac.Write(key)
+	}
+	return mac.Sum(nil)
+}
+
+/* 得到客户端到服务端和服务端到客户端两个方向的密钥和salt */
+func x25519SessionKeys(info *cipher.CipherInfo, priv *ecdh.PrivateKey, peer, psk, cpub, spub []byte) ([][]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	secret := append(shared, psk...)
+	salt := append(append([]byte{}, cpub...), spub...)
+	size := info.KeySize + info.IvSize
+	okm := cipher.HKDFSHA1(secret, salt, x25519SessionLabel, 2*size)
+	return [][]byte{
+		okm[:info.KeySize], okm[info.KeySize:size],
+		okm[size : size+info.KeySize], okm[size+info.KeySize:],
+	}, nil
+}
+
+func newX25519Listener(transport Transport, address, method, password string) (*SSListener, error) {
+	info, err := getX25519CipherInfo(method)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := transport.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	return &SSListener{
+		netListener: listener,
+		method:      method,
+		password:    password,
+		cipherInfo:  info,
+		key:         ss.CreateKey(password, x25519KeySize),
+		filter:      newReplayFilter(defaultReplayCapacity),
+		pool:        newSS2022SaltPool(),
+		handshake:   (*SSRConn).x25519Handshake,
+	}, nil
+}
+
+func (ssc *SSRConn) x25519Handshake() error {
+	l := ssc.listener
+	hello := make([]byte, x25519HelloSize)
+	if _, err := io.ReadFull(ssc.conn, hello); err != nil {
+		return err
+	}
+	cpub := hello[:x25519KeySize]
+	if !hmac.Equal(hello[x25519KeySize:], x25519MAC(l.key, x25519ClientLabel, cpub)) {
+		return errX25519Auth
+	} else if !l.filter.Check(cpub) {
+		/* 客户端每次使用新的公钥, 重放的hello直接拒绝 */
+		return errSaltReplayed
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	spub := priv.PublicKey().Bytes()
+	keys, err := x25519SessionKeys(l.cipherInfo, priv, cpub, l.key, cpub, spub)
+	if err != nil {
+		return err
+	}
+	reader, err := newSSPresetReader(l.cipherInfo, keys[0], keys[1])
+	if err != nil {
+		return err
+	}
+	writer, err := newSSPresetWriter(l.cipherInfo, keys[2], keys[3])
+	if err != nil {
+		return err
+	}
+	reply := append(spub, x25519MAC(l.key, x25519ServerLabel, cpub, spub)...)
+	if _, err := ssc.conn.Write(reply); err != nil {
+		return err
+	}
+	ssc.cipherInfo = l.cipherInfo
+	ssc.reader = reader
+	ssc.writer = writer
+	ssc.key = keys[0]
+	return nil
+}
+
+func x25519Dial(dial func() (*Conn, error), method, password string) (*SSLConn, error) {
+	info, err := getX25519CipherInfo(method)
+	if err != nil {
+		return nil, err
+	}
+	psk := ss.CreateKey(password, x25519KeySize)
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cpub := priv.PublicKey().Bytes()
+
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	ssc, err := x25519ClientHandshake(c, info, priv, psk, cpub)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ssc, nil
+}
+
+func x25519ClientHandshake(c *Conn, info *cipher.CipherInfo, priv *ecdh.PrivateKey, psk, cpub []byte) (*SSLConn, error) {
+	if _, err := c.Write(append(append([]byte{}, cpub...), x25519MAC(psk, x25519ClientLabel, cpub)...)); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, x25519HelloSize)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return nil, err
+	}
+	spub := reply[:x25519KeySize]
+	if !hmac.Equal(reply[x25519KeySize:], x25519MAC(psk, x25519ServerLabel, cpub, spub)) {
+		return nil, errX25519Auth
+	}
+	keys, err := x25519SessionKeys(info, priv, spub, psk, cpub, spub)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newSSPresetWriter(info, keys[0], keys[1])
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newSSPresetReader(info, keys[2], keys[3])
+	if err != nil {
+		return nil, err
+	}
+	return &SSLConn{
+		TConn: TConn{
+			conn: c,
+		},
+		cipherInfo: info,
+		reader:     reader,
+		writer:     writer,
+		key:        keys[0],
+	}, nil
+}
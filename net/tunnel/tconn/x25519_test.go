@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tconn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"galaxy/protocol/ss"
+	"net"
+	"testing"
+	"time"
+)
+
+func testX25519(t *testing.T, method string) {
+	l, err := NewSSListener("127.0.0.1:0", method, "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := uint16(l.netListener.Addr().(*net.TCPAddr).Port)
+
+	plain := bytes.Repeat([]byte("galaxy"), 5000)
+	result := make(chan []byte, 1)
+	go func() {
+		defer close(result)
+		c, err := SSDial("127.0.0.1", port, method, "galaxy")
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Start("example.com", 80)
+		c.Write(append([]byte{}, plain...))
+		var data []byte
+		for len(data) < len(plain) {
+			buf, err := c.Read()
+			if err != nil {
+				return
+			}
+			data = append(data, buf...)
+		}
+		result <- data
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	addr, _, err := c.Start()
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	} else if addr != "example.com" {
+		t.Fatalf("%s: Wrong Address", method)
+	}
+	var data []byte
+	for len(data) < len(plain) {
+		buf, err := c.Read()
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		data = append(data, buf...)
+	}
+	if !bytes.Equal(data, plain) {
+		t.Fatalf("%s: Wrong Request", method)
+	}
+	c.Write(append([]byte{}, data...))
+	if !bytes.Equal(<-result, plain) {
+		t.Fatalf("%s: Wrong Response", method)
+	}
+}
+
+func TestX25519(t *testing.T) {
+	testX25519(t, "x25519-aes-128-gcm")
+	testX25519(t, "x25519-aes-256-gcm")
+	testX25519(t, "x25519-chacha20-ietf-poly1305")
+}
+
+func TestX25519WrongPassword(t *testing.T) {
+	l, err := NewSSListener("127.0.0.1:0", "x25519-aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := uint16(l.netListener.Addr().(*net.TCPAddr).Port)
+	dialed := make(chan error, 1)
+	go func() {
+		c, err := SSDial("127.0.0.1", port, "x25519-aes-256-gcm", "wrong")
+		if err == nil {
+			c.Close()
+		}
+		dialed <- err
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Start(); err != errX25519Auth {
+		t.Fatalf("Wrong Password Accepted: %v", err)
+	}
+	c.Close()
+	if err := <-dialed; err == nil {
+		t.Fatal("Handshake Succeeded With Wrong Password")
+	}
+
+	if _, err := NewSSListener("127.0.0.1:0", "x25519-aes-256-cfb", "galaxy"); err == nil {
+		t.Fatal("Stream Cipher Accepted")
+	}
+}
+
+/* 重放客户端的hello */
+func TestX25519Replay(t *testing.T) {
+	l, err := NewSSListener("127.0.0.1:0", "x25519-aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	address := l.netListener.Addr().String()
+	psk := ss.CreateKey("galaxy", x25519KeySize)
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpub := priv.PublicKey().Bytes()
+	hello := append(append([]byte{}, cpub...), x25519MAC(psk, x25519ClientLabel, cpub)...)
+
+	for i, want := range []error{nil, errSaltReplayed} {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Write(hello)
+		ssc, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ssc.conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := l.handshake(ssc); err != want {
+			t.Fatalf("Hello %d: %v", i, err)
+		}
+		ssc.Close()
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package tunnel
+
+import (
+	"galaxy/net/tunnel/tconn"
+	"galaxy/protocol/socks"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+/* 远程隧道在HTTP反向代理之后, 本地隧道经由WebSocket连接反向代理 */
+func TestWebSocketTunnel(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	remote, err := NewSSTransportRemoteTunnel(tconn.NewWebSocketTransport("/galaxy", ""), "127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := url.Parse("http://" + remote.listener.Addr().String())
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	hosts := make(chan string, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+		proxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	paddr := server.Listener.Addr().(*net.TCPAddr)
+
+	local, err := NewSSLocalTunnel("127.0.0.1:0", "127.0.0.1", uint16(paddr.Port), "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SetTransport(tconn.NewWebSocketTransport("/galaxy", "galaxy.example"))
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, port); rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+	if host := <-hosts; host != "galaxy.example" {
+		t.Fatalf("Wrong Host %s", host)
+	}
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(socks.NewMethodSelectionRequest(socks.Version5, socks.MethodNoAuthRequired).Build())
+	io.ReadFull(c, make([]byte, 2))
+	c.Write(socks.NewSocks5Request(socks.Version5, socks.CMDConnect, socks.ATypeIPv4, "127.0.0.1", port).Build())
+	if rep, err := socks.ReadSocks5Reply(c); err != nil || rep.REP != socks.ReplySuccess {
+		t.Fatalf("Connect Failed: %v", err)
+	}
+	data := make([]byte, 100*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	c.Write(data)
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != string(data) {
+		t.Fatal("Wrong Data")
+	}
+}
+
+/* UDP不经过transport, transport和上游代理不能同时使用 */
+func TestWebSocketRestrictions(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	transport := tconn.NewWebSocketTransport("/galaxy", "")
+	remote, err := NewSSTransportRemoteTunnel(transport, "127.0.0.1:0", "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr := remote.listener.Addr().(*net.TCPAddr)
+	local, err := NewSSLocalTunnel("127.0.0.1:0", "127.0.0.1", uint16(raddr.Port), "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SetTransport(transport)
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+
+	if rep := testSocks5Connect(t, address, socks.CMDUDPAssociate, port); rep.REP != socks.ReplyCommandNotSupported {
+		t.Fatalf("UDP Reply %d", rep.REP)
+	}
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, port); rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	}
+
+	if err := local.SetUpstreamProxy(tconn.NewSocks5Proxy("127.0.0.1:1", "", "")); err != ErrTransportConflict {
+		t.Fatalf("Unexpected Error %v", err)
+	}
+	proxied, err := NewSSLocalTunnel("127.0.0.1:0", "127.0.0.1", uint16(raddr.Port), "aes-256-gcm", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxied.listener.Close()
+	if err := proxied.SetUpstreamProxy(tconn.NewSocks5Proxy("127.0.0.1:1", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := proxied.SetTransport(transport); err != ErrTransportConflict {
+		t.Fatalf("Unexpected Error %v", err)
+	}
+}
+
+/* 本地地址不是TCP地址的transport */
+type pipeAddrTransport struct {
+	tconn.Transport
+}
+
+type pipeAddrConn struct {
+	net.Conn
+}
+
+func (c pipeAddrConn) LocalAddr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "unix"}
+}
+
+func (tr pipeAddrTransport) Dial(address string) (net.Conn, error) {
+	c, err := tr.Transport.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	return pipeAddrConn{c}, nil
+}
+
+func TestTransportNonTCPAddr(t *testing.T) {
+	port, closeEcho := startEchoServer(t)
+	defer closeEcho()
+	local, remote := newSSTunnels(t, "aes-256-gcm", "galaxy")
+	local.SetTransport(pipeAddrTransport{tconn.TCPTransport})
+	address, stop := runSSTunnels(local, remote)
+	defer stop()
+	if rep := testSocks5Connect(t, address, socks.CMDConnect, port); rep.REP != socks.ReplySuccess {
+		t.Fatalf("Reply %d", rep.REP)
+	} else if rep.ADDR != "0.0.0.0" || rep.PORT != 0 {
+		t.Fatalf("Wrong Bound Address %s:%d", rep.ADDR, rep.PORT)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package socks
+
+import (
+	"io"
+)
+
+/*
+ * 从流中读取完整的消息
+ * 只读取消息本身的长度, 之后的数据留在reader中
+ */
+
+/* 读取n个字节追加到buf之后 */
+func readMore(r io.Reader, buf []byte, n int) ([]byte, error) {
+	l := len(buf)
+	buf = append(buf, make([]byte, n)...)
+	if _, err := io.ReadFull(r, buf[l:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+/* 读取以0结尾的字符串, 包括结尾的0 */
+func readString(r io.Reader, buf []byte) ([]byte, error) {
+	for i := 0; i < 256; i++ {
+		var err error
+		if buf, err = readMore(r, buf, 1); err != nil {
+			return nil, err
+		} else if buf[len(buf)-1] == 0 {
+			return buf, nil
+		}
+	}
+	return nil, ErrInvalidMessage
+}
+
+/* [ATYP][ADDR][PORT] */
+func readAddrPort(r io.Reader, buf []byte) ([]byte, error) {
+	buf, err := readMore(r, buf, 1)
+	if err != nil {
+		return nil, err
+	}
+	switch buf[len(buf)-1] {
+	case ATypeIPv4:
+		return readMore(r, buf, 4+2)
+	case ATypeIPv6:
+		return readMore(r, buf, 16+2)
+	case ATypeDomain:
+		if buf, err = readMore(r, buf, 1); err != nil {
+			return nil, err
+		}
+		return readMore(r, buf, int(buf[len(buf)-1])+2)
+	}
+	return nil, ErrInvalidAddrType
+}
+
+func ReadMethodSelectionRequest(r io.Reader) (*MethodSelectionRequest, error) {
+	buf, err := readMore(r, nil, 2)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = readMore(r, buf, int(buf[1])); err != nil {
+		return nil, err
+	}
+	return ParseMethodSelectionRequest(buf)
+}
+
+func ReadUsernamePasswordRequest(r io.Reader) (*UsernamePasswordRequest, error) {
+	buf, err := readMore(r, nil, 2)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = readMore(r, buf, int(buf[1])+1); err != nil {
+		return nil, err
+	}
+	if buf, err = readMore(r, buf, int(buf[len(buf)-1])); err != nil {
+		return nil, err
+	}
+	return ParseUsernamePasswordRequest(buf)
+}
+
+func ReadSocks5Request(r io.Reader) (*Socks5Request, error) {
+	buf, err := readMore(r, nil, 3)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = readAddrPort(r, buf); err != nil {
+		return nil, err
+	}
+	return ParseSocks5Request(buf)
+}
+
+func ReadSocks5Reply(r io.Reader) (*Socks5Reply, error) {
+	buf, err := readMore(r, nil, 3)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = readAddrPort(r, buf); err != nil {
+		return nil, err
+	}
+	return ParseSocks5Reply(buf)
+}
+
+/* SOCKS4a的域名在USERID之后 */
+func ReadSocks4Request(r io.Reader) (*Socks4Request, error) {
+	buf, err := readMore(r, nil, 8)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = readString(r, buf); err != nil {
+		return nil, err
+	}
+	if buf[4] == 0 && buf[5] == 0 && buf[6] == 0 && buf[7] != 0 {
+		if buf, err = readString(r, buf); err != nil {
+			return nil, err
+		}
+	}
+	return ParseSocks4Request(buf)
+}
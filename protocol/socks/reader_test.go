@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package socks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+/* 一次只读一个字节, 读完消息之后剩下的数据不应该被读走 */
+func testReadMessage(t *testing.T, msg []byte, read func(r *bytes.Reader) error) {
+	r := bytes.NewReader(append(append([]byte{}, msg...), "data"...))
+	if err := read(r); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := ioutil.ReadAll(r); string(left) != "data" {
+		t.Fatalf("Wrong Data Left %q", left)
+	}
+	for i := 0; i < len(msg); i++ {
+		if err := read(bytes.NewReader(msg[:i])); err == nil {
+			t.Fatalf("Incomplete Message Accepted At %d", i)
+		}
+	}
+}
+
+func TestReadMethodSelectionRequest(t *testing.T) {
+	msg := NewMethodSelectionRequest(Version5, MethodNoAuthRequired, MethodUsernamePassword).Build()
+	testReadMessage(t, msg, func(r *bytes.Reader) error {
+		req, err := ReadMethodSelectionRequest(iotest.OneByteReader(r))
+		if err == nil && (req.VER != Version5 || len(req.METHODS) != 2) {
+			t.Fatal("Wrong Request")
+		}
+		return err
+	})
+}
+
+func TestReadUsernamePasswordRequest(t *testing.T) {
+	msg := NewUsernamePasswordRequest(UsernamePasswordVersion, "galaxy", "secret").Build()
+	testReadMessage(t, msg, func(r *bytes.Reader) error {
+		req, err := ReadUsernamePasswordRequest(iotest.OneByteReader(r))
+		if err == nil && (req.UNAME != "galaxy" || req.PASSWD != "secret") {
+			t.Fatal("Wrong Request")
+		}
+		return err
+	})
+}
+
+func testReadSocks5Request(t *testing.T, atype byte, addr string) {
+	msg := NewSocks5Request(Version5, CMDConnect, atype, addr, 443).Build()
+	testReadMessage(t, msg, func(r *bytes.Reader) error {
+		req, err := ReadSocks5Request(iotest.OneByteReader(r))
+		if err == nil && (req.ADDR != addr || req.PORT != 443 || len(req.BUF) != 0) {
+			t.Fatal("Wrong Request")
+		}
+		return err
+	})
+}
+
+func TestReadSocks5Request(t *testing.T) {
+	testReadSocks5Request(t, ATypeIPv4, "127.0.0.1")
+	testReadSocks5Request(t, ATypeIPv6, "::1")
+	testReadSocks5Request(t, ATypeDomain, "www.baidu.com")
+
+	if _, err := ReadSocks5Request(bytes.NewReader([]byte("\x05\x01\x00\x09"))); err != ErrInvalidAddrType {
+		t.Fatal("Invalid Address Type Accepted")
+	}
+}
+
+func TestReadSocks5Reply(t *testing.T) {
+	msg := NewSocks5Reply(Version5, ReplySuccess, ATypeIPv4, "10.0.0.1", 1080).Build()
+	testReadMessage(t, msg, func(r *bytes.Reader) error {
+		rep, err := ReadSocks5Reply(iotest.OneByteReader(r))
+		if err == nil && (rep.ADDR != "10.0.0.1" || rep.PORT != 1080) {
+			t.Fatal("Wrong Reply")
+		}
+		return err
+	})
+}
+
+func TestReadSocks4Request(t *testing.T) {
+	msg := NewSocks4Request(Version4, CMDConnect, ATypeDomain, "www.baidu.com", 80, "jim").Build()
+	testReadMessage(t, msg, func(r *bytes.Reader) error {
+		req, err := ReadSocks4Request(iotest.OneByteReader(r))
+		if err == nil && (req.ADDR != "www.baidu.com" || req.USERID != "jim" || len(req.BUF) != 0) {
+			t.Fatal("Wrong Request")
+		}
+		return err
+	})
+	msg = NewSocks4Request(Version4, CMDConnect, ATypeIPv4, "127.0.0.1", 80, "").Build()
+	testReadMessage(t, msg, func(r *bytes.Reader) error {
+		req, err := ReadSocks4Request(iotest.OneByteReader(r))
+		if err == nil && req.ADDR != "127.0.0.1" {
+			t.Fatal("Wrong Request")
+		}
+		return err
+	})
+}
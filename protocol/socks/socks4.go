@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package socks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+/*
+ * 请求: [VER][CMD][PORT][IP][USERID][0]
+ * SOCKS4a中IP为0.0.0.x (x不为0), USERID之后是[域名][0]
+ * 数据不完整时返回ErrIncompleteMessage
+ */
+func ParseSocks4Request(buf []byte) (*Socks4Request, error) {
+	if len(buf) < 9 {
+		return nil, ErrIncompleteMessage
+	}
+	ver := buf[0]
+	cmd := buf[1]
+	port := binary.BigEndian.Uint16(buf[2:4])
+	ip := net.IP(buf[4:8])
+	end := bytes.IndexByte(buf[8:], 0)
+	if end < 0 {
+		return nil, ErrIncompleteMessage
+	}
+	userid := string(buf[8 : 8+end])
+	buf = buf[8+end+1:]
+
+	atype := ATypeIPv4
+	addr := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		end = bytes.IndexByte(buf, 0)
+		if end < 0 {
+			return nil, ErrIncompleteMessage
+		} else if end == 0 {
+			return nil, ErrInvalidMessage
+		}
+		atype = ATypeDomain
+		addr = string(buf[:end])
+		buf = buf[end+1:]
+	}
+	req := NewSocks4Request(ver, cmd, atype, addr, port, userid)
+	req.BUF = buf
+	return req, nil
+}
+
+func NewSocks4Request(ver, cmd, atype byte, addr string, port uint16, userid string) *Socks4Request {
+	return &Socks4Request{
+		VER:    ver,
+		CMD:    cmd,
+		PORT:   port,
+		ATYP:   atype,
+		ADDR:   addr,
+		USERID: userid,
+	}
+}
+
+func (req *Socks4Request) Build() []byte {
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.BigEndian, req.VER)
+	binary.Write(&buf, binary.BigEndian, req.CMD)
+	binary.Write(&buf, binary.BigEndian, req.PORT)
+	if req.ATYP == ATypeDomain {
+		binary.Write(&buf, binary.BigEndian, []byte{0, 0, 0, 1})
+	} else {
+		binary.Write(&buf, binary.BigEndian, []byte(net.ParseIP(req.ADDR).To4()))
+	}
+	binary.Write(&buf, binary.BigEndian, []byte(req.USERID))
+	binary.Write(&buf, binary.BigEndian, byte(0))
+	if req.ATYP == ATypeDomain {
+		binary.Write(&buf, binary.BigEndian, []byte(req.ADDR))
+		binary.Write(&buf, binary.BigEndian, byte(0))
+	}
+	return buf.Bytes()
+}
+
+func ParseSocks4Reply(buf []byte) (*Socks4Reply, error) {
+	if len(buf) != 8 {
+		return nil, ErrInvalidMessage
+	}
+	port := binary.BigEndian.Uint16(buf[2:4])
+	reply := NewSocks4Reply(buf[1], net.IP(buf[4:8]).String(), port)
+	reply.VER = buf[0]
+	return reply, nil
+}
+
+func NewSocks4Reply(rep byte, addr string, port uint16) *Socks4Reply {
+	return &Socks4Reply{
+		VER:  Socks4ReplyVersion,
+		REP:  rep,
+		PORT: port,
+		ADDR: addr,
+	}
+}
+
+/* 地址不是IPv4时为0.0.0.0 */
+func (rep *Socks4Reply) Build() []byte {
+	buf := make([]byte, 8)
+	buf[0] = rep.VER
+	buf[1] = rep.REP
+	binary.BigEndian.PutUint16(buf[2:4], rep.PORT)
+	if ip := net.ParseIP(rep.ADDR).To4(); ip != nil {
+		copy(buf[4:8], ip)
+	}
+	return buf
+}
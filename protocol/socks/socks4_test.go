@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2018 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package socks
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testSocks4Request(t *testing.T, buf []byte, atype byte, addr string, port uint16, userid string) {
+	for i := 0; i < len(buf); i++ {
+		if _, err := ParseSocks4Request(buf[:i]); err != ErrIncompleteMessage {
+			t.Fatalf("Incomplete Message Not Detected At %d", i)
+		}
+	}
+	req, err := ParseSocks4Request(append(buf, "data"...))
+	if err != nil {
+		t.Fatal(err)
+	} else if req.VER != Version4 || req.CMD != CMDConnect {
+		t.Fatal("Wrong VER/CMD")
+	} else if req.ATYP != atype || req.ADDR != addr || req.PORT != port {
+		t.Fatal("Wrong Address")
+	} else if req.USERID != userid {
+		t.Fatal("Wrong USERID")
+	} else if string(req.BUF) != "data" {
+		t.Fatal("Wrong BUF")
+	}
+	if !bytes.Equal(NewSocks4Request(Version4, CMDConnect, atype, addr, port, userid).Build(), buf) {
+		t.Fatal("Build Error")
+	}
+}
+
+func TestSocks4Request(t *testing.T) {
+	testSocks4Request(t, []byte("\x04\x01\x00\x50\x7f\x00\x00\x01jim\x00"), ATypeIPv4, "127.0.0.1", 80, "jim")
+	testSocks4Request(t, []byte("\x04\x01\x01\xbb\x7f\x00\x00\x01\x00"), ATypeIPv4, "127.0.0.1", 443, "")
+	testSocks4Request(t, []byte("\x04\x01\x00\x50\x00\x00\x00\x01jim\x00www.baidu.com\x00"), ATypeDomain, "www.baidu.com", 80, "jim")
+
+	if _, err := ParseSocks4Request([]byte("\x04\x01\x00\x50\x00\x00\x00\x01\x00\x00")); err != ErrInvalidMessage {
+		t.Fatal("Empty Domain Accepted")
+	}
+}
+
+func TestSocks4Reply(t *testing.T) {
+	buf := []byte("\x00\x5a\x00\x50\x7f\x00\x00\x01")
+	rep, err := ParseSocks4Reply(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if rep.REP != Socks4ReplyGranted || rep.ADDR != "127.0.0.1" || rep.PORT != 80 {
+		t.Fatal("Wrong Reply")
+	}
+	if !bytes.Equal(rep.Build(), buf) {
+		t.Fatal("Build Error")
+	}
+	if !bytes.Equal(NewSocks4Reply(Socks4ReplyRejected, "www.baidu.com", 0).Build(), []byte("\x00\x5b\x00\x00\x00\x00\x00\x00")) {
+		t.Fatal("Build Error")
+	}
+}
@@ -29,7 +29,7 @@ func ParseMethodSelectionRequest(buf []byte) (*MethodSelectionRequest, error) {
 	}
 	ver := buf[0]
 	nmethods := buf[1]
-	if nmethods == 0 || int(nmethods+2) != len(buf) {
+	if nmethods == 0 || int(nmethods)+2 != len(buf) {
 		return nil, ErrInvalidMessage
 	}
 	methods := make([]byte, nmethods)
@@ -83,13 +83,13 @@ func ParseUsernamePasswordRequest(buf []byte) (*UsernamePasswordRequest, error)
 		return nil, ErrInvalidMessage
 	}
 	ver := buf[0]
-	ulen := buf[1]
-	if ulen == 0 || len(buf) < int(4+ulen) {
+	ulen := int(buf[1])
+	if ulen == 0 || len(buf) < 4+ulen {
 		return nil, ErrInvalidMessage
 	}
 	uname := string(buf[2 : 2+ulen])
-	plen := buf[2+ulen]
-	if plen == 0 || len(buf) != int(3+ulen+plen) {
+	plen := int(buf[2+ulen])
+	if plen == 0 || len(buf) != 3+ulen+plen {
 		return nil, ErrInvalidMessage
 	}
 	passwd := string(buf[3+ulen:])
@@ -107,13 +107,13 @@ func NewUsernamePasswordRequest(ver byte, username, password string) *UsernamePa
 }
 
 func (req *UsernamePasswordRequest) Build() []byte {
-	buf := make([]byte, 3+req.ULEN+req.PLEN)
+	buf := make([]byte, 3+int(req.ULEN)+int(req.PLEN))
 	buf[0] = req.VER
 	buf[1] = req.ULEN
 	for i := 0; i < len(req.UNAME); i++ {
 		buf[2+i] = req.UNAME[i]
 	}
-	buf[2+req.ULEN] = req.PLEN
+	buf[2+int(req.ULEN)] = req.PLEN
 	for i := 0; i < len(req.PASSWD); i++ {
 		buf[3+int(req.ULEN)+i] = req.PASSWD[i]
 	}
@@ -141,34 +141,38 @@ func (rep *UsernamePasswordReply) Build() []byte {
 	return buf
 }
 
+/* 数据不完整时返回ErrIncompleteMessage */
 func ParseAddrPort(buf []byte) (byte, string, uint16, []byte, error) {
 	if len(buf) < 1 {
-		return 0, "", 0, nil, ErrInvalidMessage
+		return 0, "", 0, nil, ErrIncompleteMessage
 	}
 	var addr string
 	var port uint16
 	atype := buf[0]
 	if atype == ATypeIPv4 {
 		if len(buf) < 7 {
-			return atype, addr, port, nil, ErrInvalidMessage
+			return atype, addr, port, nil, ErrIncompleteMessage
 		}
 		addr = net.IP(buf[1:5]).String()
 		buf = buf[5:]
 	} else if atype == ATypeIPv6 {
 		if len(buf) < 19 {
-			return atype, addr, port, nil, ErrInvalidMessage
+			return atype, addr, port, nil, ErrIncompleteMessage
 		}
 		addr = net.IP(buf[1:17]).String()
 		buf = buf[17:]
 	} else if atype == ATypeDomain {
-		length := buf[1]
-		if len(buf) < 4+int(length) {
-			return atype, addr, port, nil, ErrInvalidMessage
+		if len(buf) < 2 {
+			return atype, addr, port, nil, ErrIncompleteMessage
+		}
+		length := int(buf[1])
+		if len(buf) < 4+length {
+			return atype, addr, port, nil, ErrIncompleteMessage
 		}
 		addr = string(buf[2:(2 + length)])
 		buf = buf[(2 + length):]
 	} else {
-		return atype, addr, port, nil, ErrInvalidMessage
+		return atype, addr, port, nil, ErrInvalidAddrType
 	}
 	binary.Read(bytes.NewReader(buf), binary.BigEndian, &port)
 	return atype, addr, port, buf[2:], nil
@@ -185,6 +189,13 @@ func BuildAddrPort(atype byte, addr string, port uint16) []byte {
 		if ip == nil {
 			return nil
 		}
+		/* IPv4地址是4字节 */
+		if atype == ATypeIPv4 {
+			ip = ip.To4()
+			if ip == nil {
+				return nil
+			}
+		}
 		binary.Write(&buf, binary.BigEndian, []byte(ip))
 	}
 	binary.Write(&buf, binary.BigEndian, port)
@@ -212,7 +223,7 @@ func GetAddrAType(addr string) byte {
 	if ip == nil {
 		return ATypeDomain
 	}
-	if len(ip) == 4 {
+	if ip.To4() != nil {
 		return ATypeIPv4
 	}
 	return ATypeIPv6
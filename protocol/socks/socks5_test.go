@@ -204,6 +204,8 @@ func testSOCKSRequest(t *testing.T, ver, cmd, atype byte, addr string, port uint
 		ip := net.ParseIP(addr)
 		if ip == nil {
 			t.Fatal("Invalid IP")
+		} else if atype == ATypeIPv4 {
+			ip = ip.To4()
 		}
 		binary.Write(&buf, binary.BigEndian, []byte(ip))
 	}
@@ -246,6 +248,8 @@ func testSOCKSReply(t *testing.T, ver, rep, atype byte, addr string, port uint16
 		ip := net.ParseIP(addr)
 		if ip == nil {
 			t.Fatal("Invalid IP")
+		} else if atype == ATypeIPv4 {
+			ip = ip.To4()
 		}
 		binary.Write(&buf, binary.BigEndian, []byte(ip))
 	}
@@ -286,6 +290,8 @@ func testSOCKSUDPMessage(t *testing.T, frag, atype byte, addr string, port uint1
 		ip := net.ParseIP(addr)
 		if ip == nil {
 			t.Fatal("Invalid IP")
+		} else if atype == ATypeIPv4 {
+			ip = ip.To4()
 		}
 		binary.Write(&buf, binary.BigEndian, []byte(ip))
 	}
@@ -302,3 +308,15 @@ func TestSOCKSUDPMessage(t *testing.T) {
 	testSOCKSUDPMessage(t, 3, ATypeIPv4, "127.0.0.1", 12345, []byte("Jim 什么？"))
 	testSOCKSUDPMessage(t, 4, ATypeIPv6, "::1", 23456, []byte("AAAA"))
 }
+
+func TestGetAddrAType(t *testing.T) {
+	if GetAddrAType("127.0.0.1") != ATypeIPv4 {
+		t.Fatal("Wrong IPv4 ATYP")
+	} else if GetAddrAType("::1") != ATypeIPv6 {
+		t.Fatal("Wrong IPv6 ATYP")
+	} else if GetAddrAType("www.baidu.com") != ATypeDomain {
+		t.Fatal("Wrong Domain ATYP")
+	} else if len(BuildAddrPort(ATypeIPv4, "127.0.0.1", 80)) != 7 {
+		t.Fatal("Wrong IPv4 Length")
+	}
+}
@@ -63,7 +63,9 @@ const (
 )
 
 var (
-	ErrInvalidMessage = errors.New("Invalid Message")
+	ErrInvalidMessage    = errors.New("Invalid Message")
+	ErrIncompleteMessage = errors.New("Incomplete Message")
+	ErrInvalidAddrType   = errors.New("Invalid Address Type")
 )
 
 type MethodSelectionRequest struct {
@@ -78,6 +80,7 @@ type MethodSelectionReply struct {
 }
 
 const (
+	UsernamePasswordVersion       = byte(0x01)
 	UsernamePasswordStatusSuccess = byte(0x00)
 	UsernamePasswordStatusFailure = byte(0x01)
 )
@@ -123,3 +126,33 @@ type Socks5UDPMessage struct {
 	PORT uint16
 	DATA []byte
 }
+
+/*
+ * Socks4 协议
+ * https://www.openssh.com/txt/socks4.protocol
+ * https://www.openssh.com/txt/socks4a.protocol
+ */
+
+const (
+	Socks4ReplyVersion  = byte(0x0)
+	Socks4ReplyGranted  = byte(0x5a)
+	Socks4ReplyRejected = byte(0x5b)
+)
+
+/* SOCKS4a中ADDR是域名, ATYP为ATypeDomain */
+type Socks4Request struct {
+	VER    byte
+	CMD    byte
+	PORT   uint16
+	ATYP   byte
+	ADDR   string
+	USERID string
+	BUF    []byte
+}
+
+type Socks4Reply struct {
+	VER  byte
+	REP  byte
+	PORT uint16
+	ADDR string
+}
@@ -20,6 +20,8 @@ package ss
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
 	"galaxy/protocol/socks"
 )
 
@@ -40,28 +42,167 @@ func CreateKey(password string, klen int) []byte {
 	return buf.Bytes()[:klen]
 }
 
+/* Shadowsocks 2022的密钥是base64编码的PSK, 长度必须和加密方式的密钥长度一致 */
+func DecodePSK(password string, klen int) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return nil, ErrInvalidPSK
+	} else if len(key) != klen {
+		return nil, ErrInvalidPSK
+	}
+	return key, nil
+}
+
 func NewAddressRequest(atype byte, addr string, port uint16) *AddressRequest {
 	return &AddressRequest{
+		CMD:  socks.CMDConnect,
 		ATYP: atype,
 		ADDR: addr,
 		PORT: port,
 	}
 }
 
+func NewBindRequest(atype byte, addr string, port uint16) *AddressRequest {
+	req := NewAddressRequest(atype, addr, port)
+	req.CMD = socks.CMDBind
+	return req
+}
+
+/* 地址是服务端要监听的地址, 服务端总是回复ConnectReply */
+func NewReverseRequest(atype byte, addr string, port uint16) *AddressRequest {
+	req := NewAddressRequest(atype, addr, port)
+	req.CMD = CMDReverse
+	req.REPLY = true
+	return req
+}
+
+/* 之后的数据是多路复用的帧, 地址没有意义 */
+func NewMuxRequest() *AddressRequest {
+	req := NewAddressRequest(socks.ATypeIPv4, "0.0.0.0", 0)
+	req.CMD = CMDMux
+	return req
+}
+
+/* 数据不完整时返回socks.ErrIncompleteMessage */
 func ParseAddressRequest(buf []byte) (*AddressRequest, error) {
-	if len(buf) < 7 {
-		return nil, ErrInvalidMessage
+	if len(buf) < 1 {
+		return nil, socks.ErrIncompleteMessage
+	} else if buf[0] == ATypeCommand {
+		return parseCommandRequest(buf)
 	}
 
+	flags := buf[0] & (FlagBind | FlagReply)
+	if flags != 0 {
+		buf = append([]byte{buf[0] &^ flags}, buf[1:]...)
+	}
 	atype, addr, port, buf, err := socks.ParseAddrPort(buf)
 	if err != nil {
 		return nil, err
 	}
 	req := NewAddressRequest(atype, addr, port)
+	if flags&FlagBind != 0 {
+		req.CMD = socks.CMDBind
+	}
+	req.REPLY = flags&FlagReply != 0
+	req.BUF = buf
+	return req, nil
+}
+
+func parseCommandRequest(buf []byte) (*AddressRequest, error) {
+	if len(buf) < 2 {
+		return nil, socks.ErrIncompleteMessage
+	}
+	cmd := buf[1]
+	if cmd != CMDReverse && cmd != CMDMux {
+		return nil, ErrInvalidMessage
+	}
+	atype, addr, port, buf, err := socks.ParseAddrPort(buf[2:])
+	if err != nil {
+		return nil, err
+	}
+	req := NewAddressRequest(atype, addr, port)
+	req.CMD = cmd
+	req.REPLY = cmd == CMDReverse
 	req.BUF = buf
 	return req, nil
 }
 
 func (req *AddressRequest) Build() []byte {
-	return socks.BuildAddrPort(req.ATYP, req.ADDR, req.PORT)
+	buf := socks.BuildAddrPort(req.ATYP, req.ADDR, req.PORT)
+	if len(buf) == 0 {
+		return buf
+	}
+	if req.CMD == CMDReverse || req.CMD == CMDMux {
+		return append([]byte{ATypeCommand, req.CMD}, buf...)
+	}
+	if req.CMD == socks.CMDBind {
+		buf[0] |= FlagBind
+	}
+	if req.REPLY {
+		buf[0] |= FlagReply
+	}
+	return buf
+}
+
+/* [REP][ATYP][ADDR][PORT], 数据不完整时返回socks.ErrIncompleteMessage */
+func ParseConnectReply(buf []byte) (*ConnectReply, error) {
+	if len(buf) < 1 {
+		return nil, socks.ErrIncompleteMessage
+	}
+	atype, addr, port, left, err := socks.ParseAddrPort(buf[1:])
+	if err != nil {
+		return nil, err
+	}
+	rep := NewConnectReply(buf[0], atype, addr, port)
+	rep.BUF = left
+	return rep, nil
+}
+
+func NewConnectReply(rep, atype byte, addr string, port uint16) *ConnectReply {
+	return &ConnectReply{
+		REP:  rep,
+		ATYP: atype,
+		ADDR: addr,
+		PORT: port,
+	}
+}
+
+func (rep *ConnectReply) Build() []byte {
+	return append([]byte{rep.REP}, socks.BuildAddrPort(rep.ATYP, rep.ADDR, rep.PORT)...)
+}
+
+/* 数据不完整时返回socks.ErrIncompleteMessage, 之后的数据在BUF中 */
+func ParseFrame(buf []byte) (*Frame, error) {
+	if len(buf) < FrameHeaderSize {
+		return nil, socks.ErrIncompleteMessage
+	}
+	cmd := buf[0]
+	if cmd < FrameOpen || cmd > FramePong {
+		return nil, ErrInvalidMessage
+	}
+	size := int(binary.BigEndian.Uint16(buf[5:7]))
+	if len(buf) < FrameHeaderSize+size {
+		return nil, socks.ErrIncompleteMessage
+	}
+	frame := NewFrame(cmd, binary.BigEndian.Uint32(buf[1:5]), buf[FrameHeaderSize:FrameHeaderSize+size])
+	frame.BUF = buf[FrameHeaderSize+size:]
+	return frame, nil
+}
+
+func NewFrame(cmd byte, id uint32, data []byte) *Frame {
+	return &Frame{
+		CMD:  cmd,
+		ID:   id,
+		DATA: data,
+	}
+}
+
+/* DATA不能超过FrameMaxDataSize */
+func (f *Frame) Build() []byte {
+	buf := make([]byte, FrameHeaderSize+len(f.DATA))
+	buf[0] = f.CMD
+	binary.BigEndian.PutUint32(buf[1:5], f.ID)
+	binary.BigEndian.PutUint16(buf[5:7], uint16(len(f.DATA)))
+	copy(buf[FrameHeaderSize:], f.DATA)
+	return buf
 }
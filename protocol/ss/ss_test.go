@@ -38,3 +38,128 @@ func TestAddressRequest(t *testing.T) {
 	testAddressRequest(t, socks.ATypeDomain, "www.baidu.com", 2334)
 	testAddressRequest(t, socks.ATypeIPv6, "::1", 22311)
 }
+
+func TestDecodePSK(t *testing.T) {
+	key, err := DecodePSK("AAECAwQFBgcICQoLDA0ODw==", 16)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(key) != 16 || key[15] != 15 {
+		t.Fatal("Wrong PSK")
+	}
+	if _, err := DecodePSK("AAECAwQFBgcICQoLDA0ODw==", 32); err != ErrInvalidPSK {
+		t.Fatal("Wrong PSK Length Accepted")
+	}
+	if _, err := DecodePSK("abcdefg", 16); err != ErrInvalidPSK {
+		t.Fatal("Invalid Base64 Accepted")
+	}
+}
+
+func TestBindRequest(t *testing.T) {
+	buf := NewBindRequest(socks.ATypeIPv4, "127.0.0.1", 21).Build()
+	if buf[0] != socks.ATypeIPv4|FlagBind {
+		t.Fatal("Wrong ATYP")
+	}
+	req, err := ParseAddressRequest(append(buf, 'x'))
+	if err != nil {
+		t.Fatal(err)
+	} else if req.CMD != socks.CMDBind || req.ATYP != socks.ATypeIPv4 {
+		t.Fatal("Wrong CMD")
+	} else if req.ADDR != "127.0.0.1" || req.PORT != 21 || string(req.BUF) != "x" {
+		t.Fatal("Wrong Address")
+	}
+	req, err = ParseAddressRequest(NewAddressRequest(socks.ATypeDomain, "www.baidu.com", 80).Build())
+	if err != nil {
+		t.Fatal(err)
+	} else if req.CMD != socks.CMDConnect {
+		t.Fatal("Wrong CMD")
+	}
+}
+
+func TestConnectReply(t *testing.T) {
+	req := NewAddressRequest(socks.ATypeDomain, "www.baidu.com", 443)
+	req.REPLY = true
+	buf := req.Build()
+	if buf[0] != socks.ATypeDomain|FlagReply {
+		t.Fatal("Wrong ATYP")
+	}
+	if req, err := ParseAddressRequest(buf); err != nil {
+		t.Fatal(err)
+	} else if !req.REPLY || req.CMD != socks.CMDConnect || req.ADDR != "www.baidu.com" {
+		t.Fatal("Wrong Request")
+	}
+
+	buf = NewConnectReply(socks.ReplyConnectionRefused, socks.ATypeIPv4, "10.0.0.1", 5000).Build()
+	for i := 0; i < len(buf); i++ {
+		if _, err := ParseConnectReply(buf[:i]); err != socks.ErrIncompleteMessage {
+			t.Fatalf("Incomplete Reply Not Detected At %d", i)
+		}
+	}
+	rep, err := ParseConnectReply(append(buf, 'x'))
+	if err != nil {
+		t.Fatal(err)
+	} else if rep.REP != socks.ReplyConnectionRefused || rep.ADDR != "10.0.0.1" || rep.PORT != 5000 {
+		t.Fatal("Wrong Reply")
+	} else if string(rep.BUF) != "x" {
+		t.Fatal("Wrong BUF")
+	}
+}
+
+func TestReverseRequest(t *testing.T) {
+	buf := NewReverseRequest(socks.ATypeIPv4, "0.0.0.0", 8080).Build()
+	if buf[0] != ATypeCommand || buf[1] != CMDReverse || buf[2] != socks.ATypeIPv4 {
+		t.Fatal("Wrong ATYP")
+	}
+	for i := 0; i < len(buf); i++ {
+		if _, err := ParseAddressRequest(buf[:i]); err != socks.ErrIncompleteMessage {
+			t.Fatalf("Incomplete Request Not Detected At %d", i)
+		}
+	}
+	req, err := ParseAddressRequest(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if req.CMD != CMDReverse || !req.REPLY {
+		t.Fatal("Wrong CMD")
+	} else if req.ADDR != "0.0.0.0" || req.PORT != 8080 {
+		t.Fatal("Wrong Address")
+	}
+}
+
+func TestMuxRequest(t *testing.T) {
+	buf := NewMuxRequest().Build()
+	if buf[0] != ATypeCommand || buf[1] != CMDMux || buf[2] != socks.ATypeIPv4 {
+		t.Fatal("Wrong ATYP")
+	}
+	if req, err := ParseAddressRequest(buf); err != nil {
+		t.Fatal(err)
+	} else if req.CMD != CMDMux || req.REPLY {
+		t.Fatal("Wrong CMD")
+	}
+	if _, err := ParseAddressRequest([]byte{ATypeCommand, 0x01, socks.ATypeIPv4}); err != ErrInvalidMessage {
+		t.Fatal("Invalid Command Accepted")
+	}
+}
+
+func TestFrame(t *testing.T) {
+	buf := NewFrame(FrameData, 0x01020304, []byte("hello")).Build()
+	for i := 0; i < len(buf); i++ {
+		if _, err := ParseFrame(buf[:i]); err != socks.ErrIncompleteMessage {
+			t.Fatalf("Incomplete Frame Not Detected At %d", i)
+		}
+	}
+	buf = append(buf, NewFrame(FrameClose, 7, nil).Build()...)
+	f, err := ParseFrame(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.CMD != FrameData || f.ID != 0x01020304 || string(f.DATA) != "hello" {
+		t.Fatal("Wrong Frame")
+	}
+	f, err = ParseFrame(f.BUF)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.CMD != FrameClose || f.ID != 7 || len(f.DATA) != 0 || len(f.BUF) != 0 {
+		t.Fatal("Wrong Frame")
+	}
+	if _, err := ParseFrame([]byte{9, 0, 0, 0, 0, 0, 0}); err != ErrInvalidMessage {
+		t.Fatal("Invalid Frame Accepted")
+	}
+}
@@ -23,11 +23,73 @@ import (
 
 var (
 	ErrInvalidMessage = errors.New("Invalid Message")
+	ErrInvalidPSK     = errors.New("Invalid PSK")
 )
 
+/*
+ * galaxy的扩展: ATYP中设置FlagBind表示BIND命令,
+ * 服务端监听一个端口, 依次返回监听的地址和连接进来的地址
+ * 设置FlagReply表示客户端等待服务端返回连接目标的结果(ConnectReply)
+ * ATYP为ATypeCommand时是galaxy的扩展命令: [ATypeCommand][CMD][ATYP][ADDR][PORT]
+ * 不占用ATYP的标志位, 多用户服务识别用户时可以匹配的ATYP不会变多
+ */
+const (
+	FlagBind  = byte(0x40)
+	FlagReply = byte(0x20)
+
+	ATypeCommand = byte(0x0F)
+)
+
+/*
+ * 扩展命令, 不是SOCKS5的命令
+ * CMDReverse表示反向隧道, 服务端监听地址中的端口, 连接进来后经由这个连接转发给客户端, 总是回复ConnectReply
+ * CMDMux表示之后的数据是多路复用的帧, 每个流连接一个目标, 地址没有意义
+ * 多路复用中服务端在每个流上先回复ConnectReply, 之后才是目标的数据
+ */
+const (
+	CMDReverse = byte(0x80)
+	CMDMux     = byte(0x81)
+)
+
+/*
+ * 反向隧道和多路复用中, 一个连接上多路复用的帧: [CMD][ID(4)][LEN(2)][DATA]
+ * FrameOpen的DATA是流对应的地址(AddressRequest), 多路复用中是目标地址, 反向隧道中是连接进来的地址
+ * FrameWindow的DATA是4字节的窗口增量, FramePing和FramePong的ID为0
+ */
+const (
+	FrameOpen   = byte(1)
+	FrameData   = byte(2)
+	FrameClose  = byte(3)
+	FrameWindow = byte(4)
+	FramePing   = byte(5)
+	FramePong   = byte(6)
+
+	FrameHeaderSize  = 7
+	FrameMaxDataSize = 0xFFFF
+)
+
+/* CMD是socks.CMDConnect, socks.CMDBind, CMDReverse或者CMDMux */
 type AddressRequest struct {
+	CMD   byte
+	REPLY bool
+	ATYP  byte
+	ADDR  string
+	PORT  uint16
+	BUF   []byte
+}
+
+/* REP是SOCKS5的回复码, 地址是服务端连接目标时使用的地址 */
+type ConnectReply struct {
+	REP  byte
 	ATYP byte
 	ADDR string
 	PORT uint16
 	BUF  []byte
 }
+
+type Frame struct {
+	CMD  byte
+	ID   uint32
+	DATA []byte
+	BUF  []byte
+}